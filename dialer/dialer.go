@@ -2,6 +2,7 @@ package dialer
 
 import (
 	"github.com/frankli0324/go-http/internal/dialer"
+	"github.com/frankli0324/go-http/internal/transport/h2c"
 )
 
 // Dialers are responsible for creating underlying streams that http requests could
@@ -20,6 +21,39 @@ type CoreDialer = dialer.CoreDialer
 
 type ProxyConfig = dialer.ProxyConfig
 
+// ProxyHop is a proxy in the chain returned by [CoreDialer.GetProxyChain],
+// with its own [ProxyConfig]
+type ProxyHop = dialer.ProxyHop
+
+// ProxyAuthenticator answers the challenges of http proxies responding with
+// 407 (Proxy Authentication Required), set in [ProxyConfig.Authenticators]
+type ProxyAuthenticator = dialer.ProxyAuthenticator
+type AuthChallenge = dialer.AuthChallenge
+
+// EnvProxyConfig selects proxies with HTTP_PROXY, HTTPS_PROXY, ALL_PROXY
+// and NO_PROXY like curl does, see [ProxyFromEnvironment]
+type EnvProxyConfig = dialer.EnvProxyConfig
+
+// ProxySelector picks the proxy of the first [ProxyRule] matching the host
+// of requests, with a fallback for the rest
+type ProxySelector = dialer.ProxySelector
+type ProxyRule = dialer.ProxyRule
+
+// ProxyFromEnvironment returns a [CoreDialer.GetProxy] reading the proxy
+// environment variables, to be used with [Client.UseGetProxy]
+var ProxyFromEnvironment = dialer.ProxyFromEnvironment
+var EnvProxyConfigFromEnvironment = dialer.EnvProxyConfigFromEnvironment
+
+// built-in [ProxyAuthenticator]s
+type (
+	BasicAuth  = dialer.BasicAuth
+	DigestAuth = dialer.DigestAuth
+	NTLMAuth   = dialer.NTLMAuth
+)
+
+// H2Config holds the options for http2 connections created by [CoreDialer]
+type H2Config = h2c.Config
+
 // we need a dedicated resolver for two scenarios:
 //
 //  1. Resolve remote address locally in proxied requests
@@ -3,6 +3,8 @@ module github.com/frankli0324/go-http
 go 1.18
 
 require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.16.7
 	golang.org/x/net v0.17.0
 	golang.org/x/sys v0.13.0
 )
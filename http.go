@@ -3,6 +3,7 @@ package http
 import (
 	"github.com/frankli0324/go-http/internal"
 	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/internal/transport/chunked"
 )
 
 // Client provides the basic API for sending HTTP requests
@@ -16,3 +17,77 @@ type Request = http.Request
 
 // Responses are high-level representations of a HTTP response.
 type Response = http.Response
+
+// ChunkExtensionHandler is called with each chunk extension of a chunked
+// HTTP/1.1 response body, see [WithChunkExtensionHandler].
+type ChunkExtensionHandler = chunked.ExtensionHandler
+
+// WithChunkExtensionHandler returns a context, responses of requests sent
+// with which report their chunk extensions (rfc9112 7.1.1) to the handler.
+var WithChunkExtensionHandler = chunked.WithExtensionHandler
+
+// RedirectPolicy controls how redirects are followed by [Client]
+type RedirectPolicy = internal.RedirectPolicy
+
+// ErrUseLastResponse could be returned by [RedirectPolicy.CheckRedirect]
+// to stop following redirects without failing the request.
+var ErrUseLastResponse = internal.ErrUseLastResponse
+
+// CookieJar stores and provides cookies for [Client], see [Client.UseCookieJar].
+// A file-backed implementation is provided in package utils/cookiejar.
+type CookieJar = http.CookieJar
+
+// DecompressionConfig controls the transparent decompression of response
+// bodies, see [Client.UseDecompression].
+type DecompressionConfig = internal.DecompressionConfig
+
+// ErrDecompressionBomb is returned reading a response body whose
+// decompressed size exceeds [DecompressionConfig.MaxRatio]
+var ErrDecompressionBomb = internal.ErrDecompressionBomb
+
+// WithExpectContinueTimeout returns a context, requests with
+// "Expect: 100-continue" sent with which wait at most d for the interim
+// response before sending the body anyway. default 1 second
+var WithExpectContinueTimeout = http.WithExpectContinueTimeout
+
+// InterimResponseHandler is called with each informational (1xx) response
+// preceding the final response, e.g. 103 (Early Hints), see
+// [WithInterimResponseHandler].
+type InterimResponseHandler = http.InterimResponseHandler
+
+// WithInterimResponseHandler returns a context, requests sent with which
+// report their interim responses to the handler.
+var WithInterimResponseHandler = http.WithInterimResponseHandler
+
+// HeaderField is a single field line of a message header
+type HeaderField = http.HeaderField
+
+// WithRawHeader returns a context, responses of requests sent with which
+// have [Response.RawHeader] set.
+var WithRawHeader = http.WithRawHeader
+
+// RawRequest is an http1 request written byte by byte as given, set it
+// in [Request.Raw] to send it.
+type RawRequest = http.RawRequest
+
+// Anomaly is a deviation from the protocol found in a response
+type Anomaly = http.Anomaly
+
+// AuditConfig receives the anomalies found in responses, see
+// [Client.UseAudit] and [WithAudit].
+type AuditConfig = http.AuditConfig
+
+// AnomalyError is returned if an anomaly is selected by [AuditConfig.Strict]
+type AnomalyError = http.AnomalyError
+
+type Severity = http.Severity
+
+const (
+	SeverityInfo    = http.SeverityInfo
+	SeverityWarning = http.SeverityWarning
+	SeverityError   = http.SeverityError
+)
+
+// WithAudit returns a context, anomalies found in responses of requests
+// sent with which are reported to the config.
+var WithAudit = http.WithAudit
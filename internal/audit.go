@@ -0,0 +1,21 @@
+package internal
+
+import (
+	"context"
+
+	"github.com/frankli0324/go-http/internal/http"
+)
+
+// UseAudit reports the anomalies found in responses to cfg, unless the
+// request context carries its own config set by [http.WithAudit].
+// nil disables it, which is the default.
+func (c *Client) UseAudit(cfg *http.AuditConfig) {
+	c.audit = cfg
+}
+
+func (c *Client) withAudit(ctx context.Context) context.Context {
+	if c.audit == nil || http.AuditFromContext(ctx) != nil {
+		return ctx
+	}
+	return http.WithAudit(ctx, c.audit)
+}
@@ -2,13 +2,20 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"io"
 
 	"github.com/frankli0324/go-http/internal/dialer"
 	"github.com/frankli0324/go-http/internal/http"
 )
 
 type Client struct {
-	dialer dialer.Dialer
+	dialer   dialer.Dialer
+	redirect *RedirectPolicy
+	jar      http.CookieJar
+
+	decompression *DecompressionConfig
+	audit         *http.AuditConfig
 }
 
 // UseDialer provides the interface to modify the dialer used for
@@ -33,14 +40,67 @@ func (c *Client) UseDialer(wrap func(dialer.Dialer) dialer.Dialer) {
 	}
 }
 
+// Close shuts down the connections held by the dialers of the client,
+// idle connections are closed immediately, while http2 connections are
+// closed gracefully after in-flight streams finish. The client is still
+// usable after closed.
+func (c *Client) Close() error {
+	d := c.dialer
+	if d == nil {
+		d = defaultDialer
+	}
+	for ; d != nil; d = d.Unwrap() {
+		if closer, ok := d.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (c *Client) CtxDo(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
 	ctx = shadowStandardClientTrace(ctx) // get rid of the httptrace provided by standard library
+	ctx = c.withAudit(ctx)
 
 	pr, err := req.Prepare()
 	if err != nil {
 		return nil, err
 	}
+	resp, pr, err = c.roundTrip(ctx, pr)
+	if err != nil || c.redirect == nil || pr.Raw != nil {
+		return resp, err
+	}
+	return c.followRedirects(ctx, pr, resp)
+}
+
+// maxNotProcessedRetries bounds the number of retries for a single request
+const maxNotProcessedRetries = 3
 
+// roundTrip sends the request, it's transparently retried if the server
+// refused to process it, e.g. http2 GOAWAY or REFUSED_STREAM, or refused
+// the compressed body, and the body could be replayed. The request sent
+// last is returned, which is sent uncompressed if the server refused.
+func (c *Client) roundTrip(ctx context.Context, pr *http.PreparedRequest) (*http.Response, *http.PreparedRequest, error) {
+	for retry := 0; ; {
+		resp, err := c.send(ctx, pr)
+		if err == nil && resp.StatusCode == 415 && pr.Compressed() && pr.Replayable() {
+			// rfc9110 15.5.16: the content coding is not acceptable,
+			// not counted as a retry since it happens only once
+			io.CopyN(io.Discard, resp.Body, maxDrainBody)
+			resp.Body.Close()
+			pr = pr.WithoutCompression()
+			continue
+		}
+		if err == nil || retry >= maxNotProcessedRetries ||
+			!pr.Replayable() || !errors.Is(err, http.ErrNotProcessed) {
+			return resp, pr, err
+		}
+		retry++
+	}
+}
+
+func (c *Client) send(ctx context.Context, pr *http.PreparedRequest) (resp *http.Response, err error) {
 	dialer := c.dialer
 	if dialer == nil {
 		dialer = defaultDialer
@@ -50,13 +110,27 @@ func (c *Client) CtxDo(ctx context.Context, req *http.Request) (resp *http.Respo
 		return nil, err
 	}
 
-	resp = new(http.Response)
-	err = conn.Do(ctx, pr, resp)
+	sent, decompress := pr, false
+	if c.jar != nil {
+		sent = c.withCookies(sent)
+	}
+	if c.decompression != nil && pr.Raw == nil {
+		sent, decompress = c.withAcceptEncoding(sent)
+	}
+
+	resp = &http.Response{URL: pr.U.String()}
+	err = conn.Do(ctx, sent, resp)
+	if err == nil && decompress {
+		err = c.decompress(resp)
+	}
 	if err != nil {
 		if resp.Body != nil {
 			resp.Body.Close()
 		}
-		resp = nil
+		return nil, err
+	}
+	if c.jar != nil {
+		c.storeCookies(pr, resp)
 	}
-	return resp, err
+	return resp, nil
 }
@@ -0,0 +1,135 @@
+package internal_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frankli0324/go-http/internal"
+	"github.com/frankli0324/go-http/internal/http"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func compressedEchoHandler(w nethttp.ResponseWriter, r *nethttp.Request) {
+	if r.URL.Path == "/reject" && r.Header.Get("Content-Encoding") != "" {
+		w.WriteHeader(415)
+		return
+	}
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		body = gr
+	case "zstd":
+		zr, err := zstd.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+	b, _ := io.ReadAll(body)
+	w.Header().Set("X-Content-Encoding", r.Header.Get("Content-Encoding"))
+	w.Header().Set("X-Content-Length", strconv.FormatInt(r.ContentLength, 10))
+	w.Header().Set("X-Transfer-Encoding", strings.Join(r.TransferEncoding, ","))
+	w.Write(b)
+}
+
+func TestClientRequestCompression(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(compressedEchoHandler))
+	t.Cleanup(server.Close)
+	h2server, h2client := newH2Server(t, compressedEchoHandler)
+	payload := strings.Repeat(`{"level":"info","msg":"hello"}`, 1000)
+
+	for _, target := range []struct {
+		client *internal.Client
+		url    string
+		te     string
+	}{{&internal.Client{}, server.URL, "chunked"}, {h2client, h2server.URL, ""}} {
+		for _, cas := range []struct {
+			path, compression, encoding string
+			expect                      bool
+		}{
+			{"/", "gzip", "gzip", false},
+			{"/", "zstd", "zstd", false},
+			{"/", "zstd", "zstd", true},    // the body is compressed after 100 (Continue)
+			{"/reject", "gzip", "", false}, // retried without compression
+		} {
+			header := http.Header{}
+			if cas.expect {
+				header.Set("Expect", "100-continue")
+			}
+			ctx := http.WithExpectContinueTimeout(context.Background(), 10*time.Second)
+			resp, err := target.client.CtxDo(ctx, &http.Request{
+				Method: "POST", URL: target.url + cas.path, Header: header,
+				Body: payload, Compression: cas.compression,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != 200 || string(b) != payload {
+				t.Fatalf("%s %s: unexpected response %d, body length %d", resp.Proto, cas.path, resp.StatusCode, len(b))
+			}
+			if got := resp.Header.Get("X-Content-Encoding"); got != cas.encoding {
+				t.Errorf("%s %s: server got Content-Encoding %q", resp.Proto, cas.path, got)
+			}
+			if cas.encoding == "" {
+				continue
+			}
+			if resp.Header.Get("X-Content-Length") != "-1" || resp.Header.Get("X-Transfer-Encoding") != target.te {
+				t.Errorf("%s: unexpected framing, content-length %s, transfer-encoding %q", resp.Proto,
+					resp.Header.Get("X-Content-Length"), resp.Header.Get("X-Transfer-Encoding"))
+			}
+		}
+	}
+}
+
+func TestClientRequestCompressionRedirect(t *testing.T) {
+	var rejected int32
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			atomic.AddInt32(&rejected, 1)
+			w.WriteHeader(415)
+			return
+		}
+		if r.URL.Path != "/final" {
+			io.Copy(io.Discard, r.Body)
+			nethttp.Redirect(w, r, "/final", 307)
+			return
+		}
+		compressedEchoHandler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	client := &internal.Client{}
+	client.UseRedirect(&internal.RedirectPolicy{})
+
+	resp, err := client.CtxDo(context.Background(), &http.Request{
+		Method: "POST", URL: server.URL + "/moved", Body: "payload", Compression: "gzip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(b) != "payload" || len(resp.Redirects) != 1 {
+		t.Errorf("unexpected response %d %q after %d redirects", resp.StatusCode, b, len(resp.Redirects))
+	}
+	if n := atomic.LoadInt32(&rejected); n != 1 {
+		t.Errorf("compressed body sent %d times after rejected", n)
+	}
+}
@@ -0,0 +1,50 @@
+package internal_test
+
+import (
+	"context"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/frankli0324/go-http/internal"
+	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/utils/cookiejar"
+)
+
+func TestClientCookieJar(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		switch r.URL.Path {
+		case "/login": // sets the cookie while redirecting
+			nethttp.SetCookie(w, &nethttp.Cookie{Name: "session", Value: "s3cr3t"})
+			nethttp.Redirect(w, r, "/echo", 302)
+		case "/echo":
+			w.Write([]byte(r.Header.Get("Cookie")))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	jar, _ := cookiejar.New(nil)
+	client := &internal.Client{}
+	client.UseCookieJar(jar)
+	client.UseRedirect(&internal.RedirectPolicy{})
+
+	for _, cas := range []struct {
+		path     string
+		header   http.Header
+		expected string
+	}{
+		{"/login", nil, "session=s3cr3t"},
+		{"/echo", http.Header{"cookie": {"user=1"}}, "user=1; session=s3cr3t"},
+	} {
+		resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: server.URL + cas.path, Header: cas.header})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != cas.expected {
+			t.Errorf("%s: server got cookie %q, expected %q", cas.path, b, cas.expected)
+		}
+	}
+}
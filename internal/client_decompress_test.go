@@ -0,0 +1,241 @@
+package internal_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/frankli0324/go-http/internal"
+	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/utils/coding"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func compress(t *testing.T, enc string, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch enc {
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "deflate":
+		w = zlib.NewWriter(buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(buf)
+	case "zstd":
+		w, _ = zstd.NewWriter(buf)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decompressHandler(t *testing.T) nethttp.HandlerFunc {
+	return func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		data := []byte("hello compressed world")
+		switch r.URL.Path {
+		case "/gzip", "/deflate", "/raw-deflate", "/br", "/zstd":
+			enc := r.URL.Path[1:]
+			data = compress(t, enc, data)
+			w.Header().Set("Content-Encoding", strings.TrimPrefix(enc, "raw-"))
+		case "/streamed":
+			// sent without Content-Length, chunked in HTTP/1.1
+			data = compress(t, "gzip", data)
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("X-Compressed-Length", strconv.Itoa(len(data)))
+			w.WriteHeader(200)
+			w.(nethttp.Flusher).Flush()
+		case "/multiple":
+			data = compress(t, "gzip", compress(t, "deflate", data))
+			w.Header().Set("Content-Encoding", "deflate, gzip")
+		case "/bomb":
+			data = compress(t, "gzip", make([]byte, 4<<20))
+			w.Header().Set("Content-Encoding", "gzip")
+		case "/unknown":
+			w.Header().Set("Content-Encoding", "unknown")
+		}
+		w.Write(data)
+	}
+}
+
+func TestClientDecompression(t *testing.T) {
+	server := httptest.NewServer(decompressHandler(t))
+	t.Cleanup(server.Close)
+	client := &internal.Client{}
+	client.UseDecompression(&internal.DecompressionConfig{})
+	h2server, h2client := newH2Server(t, decompressHandler(t))
+	h2client.UseDecompression(&internal.DecompressionConfig{})
+
+	for _, target := range []struct {
+		client *internal.Client
+		url    string
+	}{{client, server.URL}, {h2client, h2server.URL}} {
+		for _, cas := range []struct{ path, encoding string }{
+			{"/gzip", "gzip"},
+			{"/deflate", "deflate"},
+			{"/raw-deflate", "deflate"},
+			{"/br", "br"},
+			{"/zstd", "zstd"},
+			{"/multiple", "deflate, gzip"},
+			{"/streamed", "gzip"},
+			{"/unknown", ""},
+		} {
+			resp, err := target.client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: target.url + cas.path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil || string(b) != "hello compressed world" || resp.ContentEncoding != cas.encoding {
+				t.Errorf("%s %s: unexpected body %q, encoding %q, err %v", resp.Proto, cas.path, b, resp.ContentEncoding, err)
+			}
+			if resp.Header.Get("X-Accept-Encoding") != "gzip, deflate, br, zstd" {
+				t.Errorf("unexpected Accept-Encoding %q", resp.Header.Get("X-Accept-Encoding"))
+			}
+			if cas.encoding != "" && (resp.Header.Get("Content-Encoding") != "" || resp.CompressedLength <= 0) {
+				t.Errorf("%s: unexpected headers %v, compressed length %d", cas.path, resp.Header, resp.CompressedLength)
+			}
+			if l := resp.Header.Get("X-Compressed-Length"); l != "" && l != strconv.FormatInt(resp.CompressedLength, 10) {
+				t.Errorf("%s %s: compressed length %d, want %s", resp.Proto, cas.path, resp.CompressedLength, l)
+			}
+		}
+	}
+}
+
+func TestClientDecompressionLimits(t *testing.T) {
+	server := httptest.NewServer(decompressHandler(t))
+	t.Cleanup(server.Close)
+	client := &internal.Client{}
+	client.UseDecompression(&internal.DecompressionConfig{})
+
+	resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: server.URL + "/bomb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if !errors.Is(err, internal.ErrDecompressionBomb) {
+		t.Errorf("expected decompression bomb error, got %v", err)
+	}
+
+	// user defined Accept-Encoding opts out
+	resp, err = client.CtxDo(context.Background(), &http.Request{
+		Method: "GET", URL: server.URL + "/gzip",
+		Header: http.Header{"Accept-Encoding": {"gzip"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ContentEncoding != "" || resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("decompressed with user defined Accept-Encoding")
+	}
+}
+
+func TestClientTransferCoding(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		nethttp.ReadRequest(bufio.NewReader(c))
+		body := compress(t, "gzip", []byte("transfer coded"))
+		fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, chunked\r\n\r\n%x\r\n%s\r\n0\r\n\r\n", len(body), body)
+	}()
+
+	client := &internal.Client{}
+	resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: "http://" + l.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(b) != "transfer coded" {
+		t.Errorf("unexpected body %q, err %v", b, err)
+	}
+}
+
+type closeCounter struct {
+	io.ReadCloser
+	closed *int32
+}
+
+func (c closeCounter) Close() error {
+	atomic.AddInt32(c.closed, 1)
+	return c.ReadCloser.Close()
+}
+
+func TestClientTransferCodingClosed(t *testing.T) {
+	var closed int32
+	coding.RegisterDecoder("x-tracked", func(r io.Reader) (io.ReadCloser, error) {
+		dec, err := gzip.NewReader(r)
+		return closeCounter{dec, &closed}, err
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					if _, err := nethttp.ReadRequest(br); err != nil {
+						return
+					}
+					body := compress(t, "gzip", bytes.Repeat([]byte("transfer coded"), 1000))
+					fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nTransfer-Encoding: x-tracked, chunked\r\n\r\n%x\r\n%s\r\n0\r\n\r\n", len(body), body)
+				}
+			}()
+		}
+	}()
+
+	client := &internal.Client{}
+	for _, cas := range []struct{ readAll bool }{{true}, {false}} {
+		atomic.StoreInt32(&closed, 0)
+		resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: "http://" + l.Addr().String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cas.readAll {
+			io.ReadAll(resp.Body)
+		} else {
+			resp.Body.Read(make([]byte, 10))
+		}
+		resp.Body.Close()
+		if n := atomic.LoadInt32(&closed); n != 1 {
+			t.Errorf("read all %v: transfer coding decoder closed %d times", cas.readAll, n)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package internal_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frankli0324/go-http/internal"
+	"github.com/frankli0324/go-http/internal/http"
+)
+
+// trackingReader records whether the body is ever read
+type trackingReader struct {
+	r    io.Reader
+	read int32
+}
+
+func (t *trackingReader) Read(p []byte) (int, error) {
+	atomic.StoreInt32(&t.read, 1)
+	return t.r.Read(p)
+}
+
+func expectHandler(w nethttp.ResponseWriter, r *nethttp.Request) {
+	if r.URL.Path == "/reject" {
+		w.WriteHeader(413)
+		return
+	}
+	b, _ := io.ReadAll(r.Body) // 100 (Continue) is sent on the first read
+	w.Write(b)
+}
+
+func TestClientExpectContinue(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(expectHandler))
+	t.Cleanup(server.Close)
+	h2server, h2client := newH2Server(t, expectHandler)
+	// the body is only sent after 100 (Continue) if it's faster than the timeout
+	ctx := http.WithExpectContinueTimeout(context.Background(), 10*time.Second)
+
+	for _, target := range []struct {
+		client *internal.Client
+		url    string
+	}{{&internal.Client{}, server.URL}, {h2client, h2server.URL}} {
+		start := time.Now()
+		resp, err := target.client.CtxDo(ctx, &http.Request{
+			Method: "POST", URL: target.url,
+			Header: nethttp.Header{"Expect": {"100-continue"}},
+			Body:   "hello",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || string(b) != "hello" {
+			t.Fatalf("%s: unexpected response %d %q", resp.Proto, resp.StatusCode, b)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("%s: body is sent after timeout instead of 100 (Continue)", resp.Proto)
+		}
+
+		body := &trackingReader{r: strings.NewReader("hello")}
+		resp, err = target.client.CtxDo(ctx, &http.Request{
+			Method: "POST", URL: target.url + "/reject",
+			Header: nethttp.Header{"Expect": {"100-continue"}},
+			Body:   body,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 413 {
+			t.Fatalf("%s: unexpected status %d", resp.Proto, resp.StatusCode)
+		}
+		if atomic.LoadInt32(&body.read) != 0 {
+			t.Errorf("%s: body is sent after early final response", resp.Proto)
+		}
+
+		// the connection is still usable afterwards
+		resp, err = target.client.CtxDo(ctx, &http.Request{Method: "POST", URL: target.url, Body: "again"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != "again" {
+			t.Fatalf("%s: unexpected body %q", resp.Proto, b)
+		}
+	}
+}
+
+func TestClientExpectContinueTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// a server unaware of "Expect", which never sends 100 (Continue)
+		req, err := nethttp.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		b, _ := io.ReadAll(req.Body)
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n"+string(b))
+	}()
+
+	ctx := http.WithExpectContinueTimeout(context.Background(), 50*time.Millisecond)
+	resp, err := (&internal.Client{}).CtxDo(ctx, &http.Request{
+		Method: "POST", URL: "http://" + ln.Addr().String(),
+		Header: nethttp.Header{"Expect": {"100-continue"}},
+		Body:   "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "hello" {
+		t.Fatalf("unexpected body %q", b)
+	}
+}
+
+// oneShotBody fails reading once closed, like a request body streamed
+// from a file or a pipe
+type oneShotBody struct {
+	r      io.Reader
+	closed int32
+}
+
+func (b *oneShotBody) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&b.closed) != 0 {
+		return 0, errors.New("read after close")
+	}
+	return b.r.Read(p)
+}
+
+func (b *oneShotBody) Close() error {
+	atomic.StoreInt32(&b.closed, 1)
+	return nil
+}
+
+func TestClientH2ExpectContinueOneShotBody(t *testing.T) {
+	server, client := newH2Server(t, expectHandler)
+	ctx := http.WithExpectContinueTimeout(context.Background(), 10*time.Second)
+
+	for _, compression := range []string{"", "gzip"} {
+		body := &oneShotBody{r: strings.NewReader("hello")}
+		resp, err := client.CtxDo(ctx, &http.Request{
+			Method: "POST", URL: server.URL,
+			Header:      nethttp.Header{"Expect": {"100-continue"}},
+			Body:        body,
+			Compression: compression,
+		})
+		if err != nil {
+			t.Fatalf("%q: %v", compression, err)
+		}
+		var r io.Reader = resp.Body
+		if compression == "gzip" {
+			if r, err = gzip.NewReader(resp.Body); err != nil {
+				t.Fatalf("%q: %v", compression, err)
+			}
+		}
+		b, _ := io.ReadAll(r)
+		resp.Body.Close()
+		if string(b) != "hello" {
+			t.Errorf("%q: unexpected body %q", compression, b)
+		}
+		if atomic.LoadInt32(&body.closed) == 0 {
+			t.Errorf("%q: body is not closed", compression)
+		}
+	}
+
+	// the held body is closed if the final response comes first
+	body := &oneShotBody{r: strings.NewReader("hello")}
+	resp, err := client.CtxDo(ctx, &http.Request{
+		Method: "POST", URL: server.URL + "/reject",
+		Header: nethttp.Header{"Expect": {"100-continue"}},
+		Body:   body,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&body.closed) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&body.closed) == 0 {
+		t.Error("body is not closed after early final response")
+	}
+}
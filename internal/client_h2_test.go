@@ -1,24 +1,30 @@
 package internal_test
 
 import (
+	"bytes"
 	"context"
 	"crypto/x509"
-	"fmt"
+	"io"
 	nethttp "net/http"
 	"net/http/httptest"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/frankli0324/go-http/internal"
 	"github.com/frankli0324/go-http/internal/dialer"
 	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/internal/transport/h2c"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
 )
 
-func TestClientHTTP2(t *testing.T) {
-	server := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
-		w.WriteHeader(200)
-	}))
+func newH2Server(t *testing.T, h nethttp.HandlerFunc) (*httptest.Server, *internal.Client) {
+	server := httptest.NewUnstartedServer(h)
 	server.EnableHTTP2 = true
 	server.StartTLS()
+	t.Cleanup(server.Close)
 
 	client := &internal.Client{}
 	client.UseCoreDialer(func(cd *dialer.CoreDialer) dialer.Dialer {
@@ -26,7 +32,269 @@ func TestClientHTTP2(t *testing.T) {
 		cd.TLSConfig.RootCAs.AddCert(server.Certificate())
 		return cd
 	})
-	fmt.Println(client.CtxDo(context.Background(), &http.Request{
+	return server, client
+}
+
+func TestClientHTTP2(t *testing.T) {
+	server, client := newH2Server(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("server got proto %s", r.Proto)
+		}
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Echo", r.Header.Get("X-Test"))
+		w.WriteHeader(200)
+		w.Write(b)
+	})
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.CtxDo(context.Background(), &http.Request{
+			Method: "POST", URL: server.URL,
+			Header: http.Header{"X-Test": {"value"}},
+			Body:   "hello h2",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Proto != "HTTP/2.0" || resp.StatusCode != 200 {
+			t.Errorf("unexpected response %s %s", resp.Proto, resp.Status)
+		}
+		if string(b) != "hello h2" || resp.Header.Get("X-Echo") != "value" {
+			t.Errorf("unexpected echo %q, header %q", b, resp.Header.Get("X-Echo"))
+		}
+	}
+}
+
+func TestClientDisableH2(t *testing.T) {
+	server, client := newH2Server(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(200)
+	})
+	if !client.DisableH2() {
+		t.Fatal("h2 not disabled")
+	}
+	resp, err := client.CtxDo(context.Background(), &http.Request{
 		Method: "GET", URL: server.URL,
-	}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Proto != "HTTP/1.1" {
+		t.Errorf("unexpected proto %s", resp.Proto)
+	}
+}
+
+func TestClientHTTP2Multiplexed(t *testing.T) {
+	release := make(chan struct{})
+	mu, remotes := sync.Mutex{}, map[string]bool{}
+	server, client := newH2Server(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		mu.Lock()
+		remotes[r.RemoteAddr] = true
+		mu.Unlock()
+		if r.Header.Get("X-Wait") != "" {
+			<-release
+		}
+		w.WriteHeader(200)
+	})
+	// warm up, the pool learns that the origin speaks h2
+	resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.CtxDo(context.Background(), &http.Request{
+				Method: "GET", URL: server.URL, Header: http.Header{"X-Wait": {"1"}},
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if len(remotes) != 1 {
+		t.Errorf("expected concurrent requests multiplexed on 1 connection, got %d", len(remotes))
+	}
+}
+
+func TestClientHTTP2GracefulClose(t *testing.T) {
+	release := make(chan struct{})
+	server, client := newH2Server(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		<-release
+		w.Write([]byte("done"))
+	})
+	client.UseCoreDialer(func(cd *dialer.CoreDialer) dialer.Dialer {
+		cd.H2Config = &h2c.Config{GracefulTimeout: 5 * time.Second}
+		return cd
+	})
+
+	respCh := make(chan string)
+	go func() {
+		resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: server.URL})
+		if err != nil {
+			t.Error(err)
+			respCh <- ""
+			return
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		resp.Body.Close()
+		respCh <- string(b)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if b := <-respCh; b != "done" {
+		t.Errorf("in-flight stream not finished after graceful close, got %q", b)
+	}
+
+	// the client is still usable
+	resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestClientHTTP2SlowReader(t *testing.T) {
+	const bigSize = 8 << 20 // exceeds the stream window, needs WINDOW_UPDATE to complete
+	server, client := newH2Server(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path == "/big" {
+			w.Write(bytes.Repeat([]byte("a"), bigSize))
+			return
+		}
+		w.Write([]byte("small"))
+	})
+
+	big, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: server.URL + "/big"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer big.Body.Close()
+	time.Sleep(50 * time.Millisecond) // let the big body fill its buffer
+
+	// the unread body must not stall other streams on the connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	small, err := client.CtxDo(ctx, &http.Request{Method: "GET", URL: server.URL + "/small"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(small.Body)
+	small.Body.Close()
+	if err != nil || string(b) != "small" {
+		t.Fatalf("unexpected small body %q, err %v", b, err)
+	}
+
+	n, err := io.Copy(io.Discard, big.Body)
+	if err != nil || n != bigSize {
+		t.Fatalf("unexpected big body size %d, err %v", n, err)
+	}
+}
+
+func TestClientHTTP2Trailers(t *testing.T) {
+	server, client := newH2Server(t, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(b)
+		w.(nethttp.Flusher).Flush()
+		w.Header().Set("Grpc-Status", r.Trailer.Get("X-Checksum"))
+	})
+
+	for _, body := range []interface{}{"with body", nil} {
+		resp, err := client.CtxDo(context.Background(), &http.Request{
+			Method: "POST", URL: server.URL, Body: body,
+			Trailer: http.Header{"X-Checksum": {"abc"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Trailer != nil {
+			t.Error("trailers available before body EOF")
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != nil && string(b) != body {
+			t.Errorf("unexpected body %q", b)
+		}
+		if v := resp.Trailer.Get("Grpc-Status"); v != "abc" {
+			t.Errorf("unexpected trailer %q, all trailers %v", v, resp.Trailer)
+		}
+	}
+}
+
+func TestClientHTTP2HeaderOrder(t *testing.T) {
+	got := make(chan []string, 1)
+	url := rawH2Server(t, func(_ int, fr *http2.Framer, f *http2.MetaHeadersFrame) {
+		var names []string
+		for _, hf := range f.Fields {
+			names = append(names, hf.Name)
+		}
+		got <- names
+		writeRawH2Response(fr, f.StreamID, "")
+	})
+	resp, err := newH2CClient().CtxDo(context.Background(), &http.Request{
+		Method: "GET", URL: url,
+		Header:      http.Header{"X-C": {"3"}, "X-A": {"1"}, "User-Agent": {"ua"}},
+		HeaderOrder: []string{"user-agent", ":path", "x-c", ":method"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	want := []string{":path", ":method", ":authority", ":scheme", "user-agent", "x-c", "x-a"}
+	if names := <-got; !reflect.DeepEqual(names, want) {
+		t.Errorf("unexpected header order %q, want %q", names, want)
+	}
+}
+
+func TestClientHTTP2RawHeader(t *testing.T) {
+	url := rawH2Server(t, func(_ int, fr *http2.Framer, f *http2.MetaHeadersFrame) {
+		buf := &bytes.Buffer{}
+		enc := hpack.NewEncoder(buf)
+		for _, hf := range []hpack.HeaderField{
+			{Name: ":status", Value: "200"},
+			{Name: "set-cookie", Value: "a=1"},
+			{Name: "x-other", Value: "o"},
+			{Name: "set-cookie", Value: "b=2"},
+		} {
+			enc.WriteField(hf)
+		}
+		fr.WriteHeaders(http2.HeadersFrameParam{StreamID: f.StreamID, BlockFragment: buf.Bytes(), EndHeaders: true, EndStream: true})
+	})
+	ctx := http.WithRawHeader(context.Background())
+	resp, err := newH2CClient().CtxDo(ctx, &http.Request{Method: "GET", URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	want := []http.HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "set-cookie", Value: "a=1"},
+		{Name: "x-other", Value: "o"},
+		{Name: "set-cookie", Value: "b=2"},
+	}
+	if !reflect.DeepEqual(resp.RawHeader, want) {
+		t.Errorf("unexpected raw header %q", resp.RawHeader)
+	}
 }
@@ -0,0 +1,206 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frankli0324/go-http/internal"
+	"github.com/frankli0324/go-http/internal/dialer"
+	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/internal/transport/h2c"
+	errs "github.com/frankli0324/go-http/internal/transport/h2c/errors"
+	"golang.org/x/net/http2"
+	xh2c "golang.org/x/net/http2/h2c"
+)
+
+func testClientH2C(t *testing.T, priorKnowledge bool) {
+	server := httptest.NewServer(xh2c.NewHandler(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(b)
+	}), &http2.Server{}))
+	defer server.Close()
+
+	client := &internal.Client{}
+	if !client.EnableH2C(priorKnowledge) {
+		t.Fatal("failed to enable h2c")
+	}
+	for _, body := range []string{"first", "second", "third"} {
+		resp, err := client.CtxDo(context.Background(), &http.Request{
+			Method: "POST", URL: server.URL, Body: body,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Proto != "HTTP/2.0" || string(b) != body {
+			t.Errorf("unexpected response %s: %q", resp.Proto, b)
+		}
+	}
+}
+
+func TestClientH2CPriorKnowledge(t *testing.T) {
+	testClientH2C(t, true)
+}
+
+func TestClientH2CUpgrade(t *testing.T) {
+	testClientH2C(t, false)
+}
+
+func TestClientH2CUpgradeRefused(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	defer server.Close()
+
+	client := &internal.Client{}
+	client.EnableH2C(false)
+	for i := 0; i < 2; i++ {
+		resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.Proto != "HTTP/1.1" || string(b) != "HTTP/1.1" {
+			t.Errorf("unexpected response %s: %q", resp.Proto, b)
+		}
+	}
+}
+
+// blackholeRelay forwards tcp connections to target until blackholed,
+// after which the connections stay open silently, resembling a NAT
+// that dropped the mapping
+type blackholeRelay struct {
+	net.Listener
+	blackholed int32
+}
+
+func newBlackholeRelay(t *testing.T, target string) *blackholeRelay {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &blackholeRelay{Listener: l}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s, err := net.Dial("tcp", target)
+			if err != nil {
+				c.Close()
+				continue
+			}
+			go r.pipe(c, s)
+			go r.pipe(s, c)
+		}
+	}()
+	return r
+}
+
+func (r *blackholeRelay) pipe(dst, src net.Conn) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			dst.Close()
+			return
+		}
+		if atomic.LoadInt32(&r.blackholed) == 0 {
+			dst.Write(buf[:n])
+		}
+	}
+}
+
+func TestClientH2CKeepAlive(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(xh2c.NewHandler(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Header.Get("X-Wait") != "" {
+			<-release
+		}
+	}), &http2.Server{}))
+	defer server.Close()
+	defer close(release)
+	relay := newBlackholeRelay(t, server.Listener.Addr().String())
+
+	client := &internal.Client{}
+	client.UseCoreDialer(func(cd *dialer.CoreDialer) dialer.Dialer {
+		cd.H2CPriorKnowledge = true
+		cd.H2Config = &h2c.Config{ReadIdleTimeout: 50 * time.Millisecond, PingTimeout: 50 * time.Millisecond}
+		return cd
+	})
+	url := "http://" + relay.Addr().String()
+
+	// idle connections are kept alive by pings
+	resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	errCh := make(chan error)
+	go func() {
+		_, err := client.CtxDo(context.Background(), &http.Request{
+			Method: "GET", URL: url, Header: http.Header{"X-Wait": {"1"}},
+		})
+		errCh <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	atomic.StoreInt32(&relay.blackholed, 1)
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, errs.ErrPingTimeout) {
+			t.Fatal("expected ping timeout, got", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead connection not detected")
+	}
+
+	// the dead connection is removed from pool
+	atomic.StoreInt32(&relay.blackholed, 0)
+	resp, err = client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestClientH2CKeepAliveDraining(t *testing.T) {
+	// GOAWAY is sent while the stream is in flight, then the peer goes silent
+	url := rawH2Server(t, func(_ int, fr *http2.Framer, f *http2.MetaHeadersFrame) {
+		fr.WriteGoAway(f.StreamID, http2.ErrCodeNo, nil)
+	})
+	client := &internal.Client{}
+	client.UseCoreDialer(func(cd *dialer.CoreDialer) dialer.Dialer {
+		cd.H2CPriorKnowledge = true
+		cd.H2Config = &h2c.Config{ReadIdleTimeout: 50 * time.Millisecond, PingTimeout: 50 * time.Millisecond}
+		return cd
+	})
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: url})
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, errs.ErrPingTimeout) {
+			t.Fatal("expected ping timeout, got", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead connection not detected while draining")
+	}
+}
@@ -0,0 +1,76 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/frankli0324/go-http/internal"
+	"github.com/frankli0324/go-http/internal/http"
+)
+
+func earlyHintsHandler(w nethttp.ResponseWriter, r *nethttp.Request) {
+	w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+	w.WriteHeader(103)
+	w.Header().Set("Link", "</script.js>; rel=preload; as=script")
+	w.WriteHeader(103)
+	w.Header().Del("Link")
+	w.Write([]byte("final"))
+}
+
+func TestClientInterimResponses(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(earlyHintsHandler))
+	t.Cleanup(server.Close)
+	h2server, h2client := newH2Server(t, earlyHintsHandler)
+
+	for _, target := range []struct {
+		client *internal.Client
+		url    string
+	}{{&internal.Client{}, server.URL}, {h2client, h2server.URL}} {
+		var links []string
+		ctx := http.WithInterimResponseHandler(context.Background(), func(code int, header nethttp.Header) error {
+			if code != 103 {
+				t.Errorf("unexpected interim status %d", code)
+			}
+			links = append(links, header.Get("Link"))
+			return nil
+		})
+		resp, err := target.client.CtxDo(ctx, &http.Request{Method: "GET", URL: target.url})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || string(b) != "final" {
+			t.Fatalf("%s: unexpected response %d %q", resp.Proto, resp.StatusCode, b)
+		}
+		if want := []string{
+			"</style.css>; rel=preload; as=style",
+			"</script.js>; rel=preload; as=script",
+		}; !reflect.DeepEqual(links, want) {
+			t.Errorf("%s: unexpected early hints %q", resp.Proto, links)
+		}
+
+		// interim responses are skipped without a handler
+		resp, err = target.client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: target.url})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: unexpected status %d", resp.Proto, resp.StatusCode)
+		}
+
+		errAbort := errors.New("abort")
+		ctx = http.WithInterimResponseHandler(context.Background(), func(int, nethttp.Header) error {
+			return errAbort
+		})
+		if _, err := target.client.CtxDo(ctx, &http.Request{Method: "GET", URL: target.url}); !errors.Is(err, errAbort) {
+			t.Errorf("expected handler error, got %v", err)
+		}
+	}
+}
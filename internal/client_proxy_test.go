@@ -0,0 +1,379 @@
+package internal_test
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/frankli0324/go-http/internal"
+	"github.com/frankli0324/go-http/internal/dialer"
+	"github.com/frankli0324/go-http/internal/http"
+)
+
+type flushWriter struct {
+	w nethttp.ResponseWriter
+}
+
+func (f flushWriter) Write(b []byte) (int, error) {
+	n, err := f.w.Write(b)
+	f.w.(nethttp.Flusher).Flush()
+	return n, err
+}
+
+// connectProxy is an https forward proxy speaking h2 if enableH2, it counts
+// the connections accepted and the protocol versions of CONNECT requests.
+// auth could be nil, otherwise it responds to unauthorized requests.
+func connectProxy(t *testing.T, enableH2 bool, auth func(nethttp.ResponseWriter, *nethttp.Request) bool) (server *httptest.Server, conns *int32, protos chan int) {
+	conns, protos = new(int32), make(chan int, 16)
+	server = httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		protos <- r.ProtoMajor
+		if r.Method != "CONNECT" || strings.HasPrefix(r.Host, "denied.test") {
+			w.WriteHeader(403)
+			return
+		}
+		if auth != nil && !auth(w, r) {
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(502)
+			return
+		}
+		defer upstream.Close()
+		if r.ProtoMajor == 2 {
+			w.WriteHeader(200)
+			w.(nethttp.Flusher).Flush()
+			go func() {
+				io.Copy(upstream, r.Body)
+				upstream.(*net.TCPConn).CloseWrite()
+			}()
+			io.Copy(flushWriter{w}, upstream)
+			return
+		}
+		conn, brw, err := w.(nethttp.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(upstream, brw)
+		io.Copy(conn, upstream)
+	}))
+	server.EnableHTTP2 = enableH2
+	server.Config.ConnState = func(c net.Conn, s nethttp.ConnState) {
+		if s == nethttp.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return
+}
+
+func TestClientHTTPSProxy(t *testing.T) {
+	for _, enableH2 := range []bool{true, false} {
+		proxy, conns, protos := connectProxy(t, enableH2, nil)
+		var origins []string
+		for i := 0; i < 3; i++ {
+			origin := httptest.NewTLSServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				w.Write([]byte("proxied"))
+			}))
+			t.Cleanup(origin.Close)
+			origins = append(origins, origin.URL)
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(proxy.Certificate()) // shared by all httptest servers
+		client := &internal.Client{}
+		client.UseCoreDialer(func(d *dialer.CoreDialer) dialer.Dialer {
+			d.TLSConfig = &tls.Config{RootCAs: roots}
+			d.ProxyConfig = &dialer.ProxyConfig{TLSConfig: &tls.Config{RootCAs: roots}}
+			d.GetProxy = func(context.Context, *http.Request) (string, error) { return proxy.URL, nil }
+			return d
+		})
+
+		get := func(target string) {
+			resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: target})
+			if err != nil {
+				t.Errorf("h2 %v: %v", enableH2, err)
+				return
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(b) != "proxied" {
+				t.Errorf("h2 %v: unexpected body %q", enableH2, b)
+			}
+		}
+		get(origins[0]) // the pool knows the proxy connection is multiplexed afterwards
+		var wg sync.WaitGroup
+		for i := 1; i < 6; i++ {
+			wg.Add(1)
+			go func(target string) {
+				defer wg.Done()
+				get(target)
+			}(origins[i%len(origins)])
+		}
+		wg.Wait()
+
+		_, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: "https://denied.test"})
+		if err == nil || !strings.Contains(err.Error(), "status:403") {
+			t.Errorf("h2 %v: expected the tunnel to be denied, got %v", enableH2, err)
+		}
+		close(protos)
+		for proto := range protos {
+			if want := map[bool]int{true: 2, false: 1}[enableH2]; proto != want {
+				t.Errorf("h2 %v: CONNECT sent over HTTP/%d", enableH2, proto)
+			}
+		}
+		// tunnels over h2 share a single connection to the proxy
+		if n := atomic.LoadInt32(conns); enableH2 && n != 1 || !enableH2 && n < 3 {
+			t.Errorf("h2 %v: %d connections made to the proxy", enableH2, n)
+		}
+	}
+}
+
+var digestParam = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^\s,]+))`)
+
+// digestAuth accepts the Digest credentials of user "Mufasa" with qop "auth"
+func digestAuth(w nethttp.ResponseWriter, r *nethttp.Request) bool {
+	params := map[string]string{}
+	for _, m := range digestParam.FindAllStringSubmatch(strings.TrimPrefix(r.Header.Get("Proxy-Authorization"), "Digest "), -1) {
+		params[m[1]] = m[2] + m[3]
+	}
+	h := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1, ha2 := h("Mufasa:proxy:Circle of Life"), h("CONNECT:"+params["uri"])
+	expected := h(ha1 + ":nonce:" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	if params["uri"] == r.Host && params["response"] == expected {
+		return true
+	}
+	w.Header().Set("Proxy-Authenticate", `Basic realm="proxy", Digest realm="proxy", nonce="nonce", qop="auth"`)
+	w.WriteHeader(407)
+	fmt.Fprint(w, "authentication required")
+	return false
+}
+
+func TestClientProxyAuthentication(t *testing.T) {
+	for _, enableH2 := range []bool{true, false} {
+		proxy, conns, _ := connectProxy(t, enableH2, digestAuth)
+		origin := httptest.NewTLSServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			w.Write([]byte("proxied"))
+		}))
+		t.Cleanup(origin.Close) // closed before the proxy, ending the tunnels
+		roots := x509.NewCertPool()
+		roots.AddCert(proxy.Certificate())
+		for _, cas := range []struct {
+			user string
+			ok   bool
+		}{{"Mufasa:Circle%20of%20Life", true}, {"Mufasa:wrong", false}} {
+			client := &internal.Client{}
+			client.UseCoreDialer(func(d *dialer.CoreDialer) dialer.Dialer {
+				d.TLSConfig = &tls.Config{RootCAs: roots}
+				d.ProxyConfig = &dialer.ProxyConfig{TLSConfig: &tls.Config{RootCAs: roots}}
+				d.GetProxy = func(context.Context, *http.Request) (string, error) {
+					return strings.Replace(proxy.URL, "://", "://"+cas.user+"@", 1), nil
+				}
+				return d
+			})
+			resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: origin.URL})
+			if !cas.ok {
+				if err == nil || !strings.Contains(err.Error(), "status:407") {
+					t.Errorf("h2 %v: expected 407, got %v", enableH2, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("h2 %v: %v", enableH2, err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(b) != "proxied" {
+				t.Errorf("h2 %v: unexpected body %q", enableH2, b)
+			}
+			// the preemptive Basic credentials are rejected, then Digest is
+			// answered on the same connection
+			if n := atomic.LoadInt32(conns); n != 1 {
+				t.Errorf("h2 %v: %d connections made to the proxy", enableH2, n)
+			}
+		}
+	}
+}
+
+func TestClientProxyChain(t *testing.T) {
+	proxy, conns, protos := connectProxy(t, true, nil)
+	jump, dests := socksServer(t, "", "")
+	var origins []string
+	for i := 0; i < 2; i++ {
+		origin := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			w.Write([]byte("proxied"))
+		}))
+		t.Cleanup(origin.Close)
+		origins = append(origins, origin.URL)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(proxy.Certificate())
+	chain := []dialer.ProxyHop{
+		{URL: "socks5h://" + jump},
+		{URL: proxy.URL, Config: &dialer.ProxyConfig{TLSConfig: &tls.Config{RootCAs: roots}}},
+	}
+	client := &internal.Client{}
+	client.UseGetProxyChain(func(context.Context, *http.Request) ([]dialer.ProxyHop, error) { return chain, nil })
+
+	for _, origin := range append(origins, origins[0]) {
+		resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: origin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != "proxied" {
+			t.Errorf("unexpected body %q", b)
+		}
+	}
+	// the tunnels to both origins share the h2 connection made through the jump host
+	if dest := <-dests; dest != proxy.Listener.Addr().String() {
+		t.Errorf("jump host asked for %s", dest)
+	}
+	if n := atomic.LoadInt32(conns); n != 1 {
+		t.Errorf("%d connections made to the proxy", n)
+	}
+
+	// the chain is part of the pool keys, a direct connection to the same
+	// proxy is not shared with the chain
+	client.UseGetProxyChain(func(context.Context, *http.Request) ([]dialer.ProxyHop, error) { return chain[1:], nil })
+	resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: origins[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := atomic.LoadInt32(conns); n != 2 {
+		t.Errorf("%d connections made to the proxy", n)
+	}
+	select {
+	case dest := <-dests:
+		t.Errorf("jump host used for the direct proxy, asked for %s", dest)
+	default:
+	}
+	close(protos)
+	for proto := range protos {
+		if proto != 2 {
+			t.Errorf("CONNECT sent over HTTP/%d", proto)
+		}
+	}
+}
+
+func TestClientProxyChainConfig(t *testing.T) {
+	proxy, conns, _ := connectProxy(t, true, nil)
+	origin := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte("proxied"))
+	}))
+	t.Cleanup(origin.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(proxy.Certificate())
+	trusted := &dialer.ProxyConfig{TLSConfig: &tls.Config{RootCAs: roots}}
+	untrusted := &dialer.ProxyConfig{TLSConfig: &tls.Config{RootCAs: x509.NewCertPool()}}
+	var cfg *dialer.ProxyConfig
+	client := &internal.Client{}
+	client.UseGetProxyChain(func(context.Context, *http.Request) ([]dialer.ProxyHop, error) {
+		return []dialer.ProxyHop{{URL: proxy.URL, Config: cfg}}, nil
+	})
+	get := func() error {
+		resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: origin.URL})
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	cfg = trusted
+	for i := 0; i < 2; i++ {
+		if err := get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the same URLs with different settings never share the connections
+	cfg = untrusted
+	var certErr x509.UnknownAuthorityError
+	if err := get(); !errors.As(err, &certErr) {
+		t.Errorf("expected the proxy to be untrusted, got %v", err)
+	}
+	cfg = trusted.Clone()
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(conns); n != 3 {
+		t.Errorf("%d connections made to the proxy", n)
+	}
+}
+
+func TestClientProxyFallbacks(t *testing.T) {
+	proxy, conns, _ := connectProxy(t, false, nil)
+	denying, _, _ := connectProxy(t, false, func(w nethttp.ResponseWriter, r *nethttp.Request) bool {
+		w.WriteHeader(403)
+		return false
+	})
+	origin := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(origin.Close)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := "http://" + l.Addr().String()
+	l.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(proxy.Certificate())
+	for _, cas := range []struct {
+		rule    dialer.ProxyRule
+		conns   int32
+		success bool
+	}{
+		{dialer.ProxyRule{Proxy: dead, Fallbacks: []string{proxy.URL, "direct"}}, 1, true},
+		{dialer.ProxyRule{Proxy: dead, Fallbacks: []string{dead, "direct"}}, 0, true},
+		// the proxy is reachable, the request is not retried directly
+		{dialer.ProxyRule{Proxy: denying.URL, Fallbacks: []string{"direct"}}, 0, false},
+	} {
+		atomic.StoreInt32(conns, 0)
+		cas.rule.Match = "*"
+		client := &internal.Client{}
+		client.UseCoreDialer(func(d *dialer.CoreDialer) dialer.Dialer {
+			d.ProxyConfig = &dialer.ProxyConfig{TLSConfig: &tls.Config{RootCAs: roots}}
+			d.GetProxies = (&dialer.ProxySelector{Rules: []dialer.ProxyRule{cas.rule}}).GetProxies()
+			return d
+		})
+		resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: origin.URL})
+		if (err == nil) != cas.success {
+			t.Errorf("%v: unexpected error %v", cas.rule, err)
+			continue
+		}
+		if err == nil {
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(b) != "ok" {
+				t.Errorf("%v: unexpected body %q", cas.rule, b)
+			}
+		}
+		if n := atomic.LoadInt32(conns); n != cas.conns {
+			t.Errorf("%v: %d connections made to the fallback proxy", cas.rule, n)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package internal_test
+
+import (
+	"context"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/frankli0324/go-http/internal"
+	"github.com/frankli0324/go-http/internal/http"
+)
+
+func newRedirectServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			nethttp.Redirect(w, r, "/loop", 302)
+		case "/final":
+			b, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Method", r.Method)
+			w.Header().Set("X-Authorization", r.Header.Get("Authorization"))
+			w.Header().Set("X-Cookie", r.Header.Get("Cookie"))
+			w.Write(b)
+		default: // /<code>?to=<location>
+			code := 0
+			for _, c := range r.URL.Path[1:] {
+				code = code*10 + int(c-'0')
+			}
+			io.Copy(io.Discard, r.Body)
+			w.Header().Set("Location", r.URL.Query().Get("to"))
+			w.WriteHeader(code)
+			w.Write([]byte("redirecting"))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientRedirectMethod(t *testing.T) {
+	server := newRedirectServer(t)
+	client := &internal.Client{}
+	client.UseRedirect(&internal.RedirectPolicy{})
+
+	for _, cas := range []struct {
+		code   string
+		method string
+		body   string
+	}{
+		{"301", "GET", ""}, {"302", "GET", ""}, {"303", "GET", ""},
+		{"307", "POST", "payload"}, {"308", "POST", "payload"},
+	} {
+		resp, err := client.CtxDo(context.Background(), &http.Request{
+			Method: "POST", URL: server.URL + "/" + cas.code + "?to=/final",
+			Header: http.Header{"Content-Type": {"text/plain"}},
+			Body:   "payload",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || resp.Header.Get("X-Method") != cas.method || string(b) != cas.body {
+			t.Errorf("%s: unexpected response %d %s %q", cas.code, resp.StatusCode, resp.Header.Get("X-Method"), b)
+		}
+		if resp.URL != server.URL+"/final" || len(resp.Redirects) != 1 ||
+			resp.Redirects[0].URL != server.URL+"/"+cas.code+"?to=/final" {
+			t.Errorf("%s: unexpected redirect chain to %s", cas.code, resp.URL)
+		}
+	}
+}
+
+func TestClientRedirectCrossOrigin(t *testing.T) {
+	server, other := newRedirectServer(t), newRedirectServer(t)
+	client := &internal.Client{}
+	client.UseRedirect(&internal.RedirectPolicy{})
+
+	for _, cas := range []struct {
+		to          string
+		credentials bool
+	}{{"/final", true}, {other.URL + "/final", false}} {
+		resp, err := client.CtxDo(context.Background(), &http.Request{
+			Method: "GET", URL: server.URL + "/302?to=" + cas.to,
+			Header: http.Header{"authorization": {"Bearer x"}, "Cookie": {"a=b"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		got := resp.Header.Get("X-Authorization") != "" || resp.Header.Get("X-Cookie") != ""
+		if got != cas.credentials {
+			t.Errorf("redirect to %s: credentials sent %v", cas.to, got)
+		}
+	}
+}
+
+func TestClientRedirectPolicy(t *testing.T) {
+	server := newRedirectServer(t)
+	client := &internal.Client{}
+
+	// not followed by default
+	resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: server.URL + "/302?to=/final"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 302 {
+		t.Errorf("redirect followed without policy, got %d", resp.StatusCode)
+	}
+
+	client.UseRedirect(&internal.RedirectPolicy{MaxHops: 3})
+	if _, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: server.URL + "/loop"}); err == nil ||
+		!strings.Contains(err.Error(), "stopped after 3 redirects") {
+		t.Errorf("unexpected error for redirect loop: %v", err)
+	}
+
+	client.UseRedirect(&internal.RedirectPolicy{
+		CheckRedirect: func(next *http.Request, via []*http.Response) error {
+			if len(via) == 2 {
+				return internal.ErrUseLastResponse
+			}
+			return nil
+		},
+	})
+	resp, err = client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: server.URL + "/loop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 302 || len(resp.Redirects) != 1 || !strings.Contains(string(b), "Found") {
+		t.Errorf("unexpected last response %d, %d redirects, body %q", resp.StatusCode, len(resp.Redirects), b)
+	}
+}
+
+func TestClientRedirectCheckHeader(t *testing.T) {
+	server, other := newRedirectServer(t), newRedirectServer(t)
+	client := &internal.Client{}
+
+	var seen http.Header
+	client.UseRedirect(&internal.RedirectPolicy{
+		CheckRedirect: func(next *http.Request, via []*http.Response) error {
+			seen = next.Header.Clone()
+			next.Header.Set("Cookie", "c=d")
+			return nil
+		},
+	})
+	req := &http.Request{
+		Method: "GET", URL: server.URL + "/302?to=" + other.URL + "/final",
+		Header: http.Header{"Authorization": {"Bearer x"}},
+	}
+	resp, err := client.CtxDo(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if seen == nil || seen.Get("Authorization") != "" {
+		t.Errorf("credentials passed to CheckRedirect: %v", seen)
+	}
+	if resp.Header.Get("X-Authorization") != "" || resp.Header.Get("X-Cookie") != "c=d" {
+		t.Errorf("unexpected headers sent %q %q", resp.Header.Get("X-Authorization"), resp.Header.Get("X-Cookie"))
+	}
+	if len(req.Header) != 1 || req.Header.Get("Authorization") != "Bearer x" {
+		t.Errorf("original request modified: %v", req.Header)
+	}
+}
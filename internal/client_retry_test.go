@@ -0,0 +1,272 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frankli0324/go-http/internal"
+	"github.com/frankli0324/go-http/internal/dialer"
+	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/internal/transport"
+	"github.com/frankli0324/go-http/internal/transport/h2c"
+	errs "github.com/frankli0324/go-http/internal/transport/h2c/errors"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// rawH2Server accepts h2c prior knowledge connections advertising settings,
+// and calls onHeaders for each request HEADERS frame received on the framer.
+// PING frames are never acknowledged.
+func rawH2Server(t *testing.T, onHeaders func(connIdx int, fr *http2.Framer, f *http2.MetaHeadersFrame), settings ...http2.Setting) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for connIdx := 0; ; connIdx++ {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(connIdx int, c net.Conn) {
+				defer c.Close()
+				preface := make([]byte, len(http2.ClientPreface))
+				if _, err := io.ReadFull(c, preface); err != nil {
+					return
+				}
+				fr := http2.NewFramer(c, c)
+				fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+				fr.WriteSettings(settings...)
+				for {
+					f, err := fr.ReadFrame()
+					if err != nil {
+						return
+					}
+					switch f := f.(type) {
+					case *http2.SettingsFrame:
+						if !f.IsAck() {
+							fr.WriteSettingsAck()
+						}
+					case *http2.MetaHeadersFrame:
+						onHeaders(connIdx, fr, f)
+					}
+				}
+			}(connIdx, c)
+		}
+	}()
+	return "http://" + l.Addr().String()
+}
+
+func writeRawH2Response(fr *http2.Framer, streamID uint32, body string) {
+	buf := &bytes.Buffer{}
+	enc := hpack.NewEncoder(buf)
+	enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+	fr.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, BlockFragment: buf.Bytes(), EndHeaders: true})
+	fr.WriteData(streamID, true, []byte(body))
+}
+
+func newH2CClient() *internal.Client {
+	client := &internal.Client{}
+	client.UseCoreDialer(func(cd *dialer.CoreDialer) dialer.Dialer {
+		cd.H2CPriorKnowledge = true
+		return cd
+	})
+	return client
+}
+
+func TestClientRetryRefusedStream(t *testing.T) {
+	refused := 0
+	url := rawH2Server(t, func(_ int, fr *http2.Framer, f *http2.MetaHeadersFrame) {
+		if refused < 2 {
+			refused++
+			fr.WriteRSTStream(f.StreamID, http2.ErrCodeRefusedStream)
+			return
+		}
+		writeRawH2Response(fr, f.StreamID, "ok")
+	})
+	resp, err := newH2CClient().CtxDo(context.Background(), &http.Request{Method: "POST", URL: url, Body: "body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "ok" || refused != 2 {
+		t.Errorf("unexpected response %q after %d refused", b, refused)
+	}
+}
+
+func TestClientRetryGoAway(t *testing.T) {
+	url := rawH2Server(t, func(connIdx int, fr *http2.Framer, f *http2.MetaHeadersFrame) {
+		if connIdx == 0 {
+			fr.WriteGoAway(0, http2.ErrCodeNo, nil)
+			return
+		}
+		writeRawH2Response(fr, f.StreamID, "ok")
+	})
+	resp, err := newH2CClient().CtxDo(context.Background(), &http.Request{Method: "GET", URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "ok" {
+		t.Errorf("unexpected response %q", b)
+	}
+}
+
+func TestClientNoRetryOneShotBody(t *testing.T) {
+	url := rawH2Server(t, func(_ int, fr *http2.Framer, f *http2.MetaHeadersFrame) {
+		fr.WriteRSTStream(f.StreamID, http2.ErrCodeRefusedStream)
+	})
+	_, err := newH2CClient().CtxDo(context.Background(), &http.Request{
+		Method: "POST", URL: url, Body: io.MultiReader(strings.NewReader("body")),
+	})
+	if !errors.Is(err, http.ErrNotProcessed) {
+		t.Fatal("expected not processed error, got", err)
+	}
+}
+
+func TestClientCloseAfterRemoteGoAway(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	closed := make(chan struct{})
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		preface := make([]byte, len(http2.ClientPreface))
+		if _, err := io.ReadFull(c, preface); err != nil {
+			return
+		}
+		fr := http2.NewFramer(c, c)
+		fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+		fr.WriteSettings()
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				close(closed) // closed by the client
+				return
+			}
+			switch f := f.(type) {
+			case *http2.SettingsFrame:
+				if !f.IsAck() {
+					fr.WriteSettingsAck()
+				}
+			case *http2.MetaHeadersFrame:
+				// the socket is left open after GOAWAY
+				writeRawH2Response(fr, f.StreamID, "ok")
+				fr.WriteGoAway(f.StreamID, http2.ErrCodeNo, nil)
+			}
+		}
+	}()
+
+	client := newH2CClient()
+	resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: "http://" + l.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	time.Sleep(50 * time.Millisecond) // GOAWAY received
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not closed after remote GOAWAY")
+	}
+}
+
+func TestH2RemoteGoAwayAfterClose(t *testing.T) {
+	framers := make(chan *http2.Framer, 2)
+	url := rawH2Server(t, func(_ int, fr *http2.Framer, f *http2.MetaHeadersFrame) {
+		framers <- fr // responded after GOAWAY
+	})
+	c, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := transport.NewH2Conn(c, &h2c.Config{})
+	if err := conn.Setup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	do := func(errCh chan<- error) {
+		sess, err := conn.Session(context.Background(), nil)
+		if err == nil {
+			req, _ := (&http.Request{Method: "GET", URL: url}).Prepare()
+			resp := &http.Response{}
+			if err = sess.(*transport.H2Session).Do(context.Background(), req, resp); err == nil {
+				io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+		}
+		errCh <- err
+	}
+	processedErr, refusedErr := make(chan error, 1), make(chan error, 1)
+	go do(processedErr)
+	fr := <-framers // stream 1
+	go do(refusedErr)
+	<-framers // stream 3
+
+	// GOAWAY is sent by us first, the peer only processes stream 1
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	fr.WriteGoAway(1, http2.ErrCodeNo, nil)
+	if err := <-refusedErr; !errors.Is(err, http.ErrNotProcessed) {
+		t.Errorf("expected the stream above the last stream ID to be retryable, got %v", err)
+	}
+	writeRawH2Response(fr, 1, "ok")
+	if err := <-processedErr; err != nil {
+		t.Errorf("unexpected error for the processed stream %v", err)
+	}
+}
+
+func TestH2NotSentAfterPingTimeout(t *testing.T) {
+	url := rawH2Server(t, func(int, *http2.Framer, *http2.MetaHeadersFrame) {
+		// the peer goes silent after the first request
+	}, http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 1})
+	c, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := transport.NewH2Conn(c, &h2c.Config{ReadIdleTimeout: 50 * time.Millisecond, PingTimeout: 50 * time.Millisecond})
+	defer conn.Close()
+	if err := conn.Setup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	do := func(errCh chan<- error) {
+		sess, err := conn.Session(context.Background(), nil)
+		if err == nil {
+			req, _ := (&http.Request{Method: "GET", URL: url}).Prepare()
+			err = sess.(*transport.H2Session).Do(context.Background(), req, &http.Response{})
+		}
+		errCh <- err
+	}
+	sentErr, waitingErr := make(chan error, 1), make(chan error, 1)
+	go do(sentErr)
+	time.Sleep(20 * time.Millisecond)
+	go do(waitingErr) // waits for the stream slot until the connection dies
+
+	// the request sent might have been processed
+	if err := <-sentErr; !errors.Is(err, errs.ErrPingTimeout) || errors.Is(err, http.ErrNotProcessed) {
+		t.Errorf("expected ping timeout, got %v", err)
+	}
+	if err := <-waitingErr; !errors.Is(err, http.ErrNotProcessed) {
+		t.Errorf("expected the request not sent to be retryable, got %v", err)
+	}
+}
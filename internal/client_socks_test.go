@@ -0,0 +1,247 @@
+package internal_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frankli0324/go-http/internal"
+	"github.com/frankli0324/go-http/internal/dialer"
+	"github.com/frankli0324/go-http/internal/http"
+)
+
+// socksServer is a minimal socks4/4a/5 proxy, it reports the destination
+// requested by the client, where "example.test" is resolved to localhost.
+func socksServer(t *testing.T, user, pass string) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	dests := make(chan string, 16)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				host, port, ok := socksHandshake(br, c, user, pass)
+				if !ok {
+					return
+				}
+				dests <- net.JoinHostPort(host, strconv.Itoa(port))
+				if host == "example.test" {
+					host = "127.0.0.1"
+				}
+				upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go io.Copy(upstream, br)
+				io.Copy(c, upstream)
+			}(c)
+		}
+	}()
+	return l.Addr().String(), dests
+}
+
+func socksHandshake(br *bufio.Reader, c net.Conn, user, pass string) (host string, port int, ok bool) {
+	ver, _ := br.ReadByte()
+	switch ver {
+	case 4:
+		var hdr [7]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return
+		}
+		port = int(binary.BigEndian.Uint16(hdr[1:3]))
+		id, _ := br.ReadString(0)
+		if id[:len(id)-1] != user {
+			c.Write([]byte{0, 91, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		host = net.IP(hdr[3:7]).String()
+		if hdr[3] == 0 && hdr[4] == 0 && hdr[5] == 0 && hdr[6] != 0 {
+			name, _ := br.ReadString(0)
+			host = name[:len(name)-1]
+		}
+		c.Write([]byte{0, 90, 0, 0, 0, 0, 0, 0})
+		return host, port, true
+	case 5:
+		n, _ := br.ReadByte()
+		methods := make([]byte, n)
+		io.ReadFull(br, methods)
+		if user == "" {
+			c.Write([]byte{5, 0})
+		} else {
+			c.Write([]byte{5, 2})
+			var buf [256]byte
+			br.ReadByte() // version
+			ul, _ := br.ReadByte()
+			io.ReadFull(br, buf[:ul])
+			u := string(buf[:ul])
+			pl, _ := br.ReadByte()
+			io.ReadFull(br, buf[:pl])
+			if u != user || string(buf[:pl]) != pass {
+				c.Write([]byte{1, 1})
+				return
+			}
+			c.Write([]byte{1, 0})
+		}
+		var req [4]byte
+		io.ReadFull(br, req[:])
+		switch req[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(br, ip)
+			host = net.IP(ip).String()
+		case 4:
+			ip := make([]byte, 16)
+			io.ReadFull(br, ip)
+			host = net.IP(ip).String()
+		case 3:
+			l, _ := br.ReadByte()
+			name := make([]byte, l)
+			io.ReadFull(br, name)
+			host = string(name)
+		}
+		var p [2]byte
+		io.ReadFull(br, p[:])
+		c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		return host, int(binary.BigEndian.Uint16(p[:])), true
+	}
+	return
+}
+
+func TestClientSocksProxy(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte("proxied"))
+	}))
+	t.Cleanup(server.Close)
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	target := "http://example.test:" + port
+
+	for _, cas := range []struct {
+		scheme, user   string
+		resolveLocally bool
+		dest           string
+	}{
+		{"socks4", "id", false, "127.0.0.1"},
+		{"socks4a", "id", false, "example.test"},
+		{"socks4a", "id", true, "127.0.0.1"},
+		{"socks5", "", false, "127.0.0.1"},
+		{"socks5h", "", false, "example.test"},
+		{"socks5h", "user:pass", false, "example.test"},
+		{"socks5h", "user:pass", true, "127.0.0.1"},
+	} {
+		user, pass := cas.user, ""
+		if i := strings.Index(cas.user, ":"); i != -1 {
+			user, pass = cas.user[:i], cas.user[i+1:]
+		}
+		addr, dests := socksServer(t, user, pass)
+		proxy := cas.scheme + "://" + addr
+		if cas.user != "" {
+			proxy = cas.scheme + "://" + cas.user + "@" + addr
+		}
+
+		client := &internal.Client{}
+		client.UseCoreDialer(func(d *dialer.CoreDialer) dialer.Dialer {
+			d.GetProxy = func(context.Context, *http.Request) (string, error) { return proxy, nil }
+			d.ProxyConfig = &dialer.ProxyConfig{
+				ResolveLocally: cas.resolveLocally,
+				ResolveConfig:  &dialer.ResolveConfig{StaticHosts: map[string]string{"example.test": "127.0.0.1"}},
+			}
+			return d
+		})
+		resp, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: target})
+		if err != nil {
+			t.Fatalf("%s: %v", proxy, err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != "proxied" {
+			t.Errorf("%s: unexpected body %q", proxy, b)
+		}
+		if dest := <-dests; dest != net.JoinHostPort(cas.dest, port) {
+			t.Errorf("%s: proxy asked for %s, want %s", proxy, dest, cas.dest)
+		}
+	}
+}
+
+func TestClientSocks5AuthFailure(t *testing.T) {
+	addr, _ := socksServer(t, "user", "pass")
+	client := &internal.Client{}
+	client.UseGetProxy(func(context.Context, *http.Request) (string, error) {
+		return "socks5h://user:wrong@" + addr, nil
+	})
+	if _, err := client.CtxDo(context.Background(), &http.Request{Method: "GET", URL: "http://example.test"}); err == nil {
+		t.Fatal("expected authentication failure")
+	}
+}
+
+func TestSocksHandshakeCancelled(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	addr, dests := socksServer(t, "", "")
+	go func() {
+		for range dests {
+		}
+	}()
+	proxy, _ := url.Parse("socks5h://" + addr)
+	remote, _ := url.Parse("http://" + echo.Addr().String())
+	d := &dialer.CoreDialer{}
+
+	// the context is cancelled around the end of the handshake, a connection
+	// returned without error must stay usable
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func(delay time.Duration) {
+			time.Sleep(delay)
+			cancel()
+		}(time.Duration(i%20) * 10 * time.Microsecond)
+		conn, err := d.DialContextOverProxy(ctx, remote, proxy)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			continue
+		}
+		time.Sleep(time.Millisecond)
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatalf("connection unusable after handshake: %v", err)
+		}
+		var b [4]byte
+		if _, err := io.ReadFull(conn, b[:]); err != nil {
+			t.Fatalf("connection unusable after handshake: %v", err)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	nethttp "net/http"
+	"strings"
+
+	"github.com/frankli0324/go-http/internal/http"
+)
+
+// UseCookieJar sets the jar the client fills "Cookie" request header from,
+// and stores cookies from "Set-Cookie" response headers into. nil disables
+// cookie handling, which is the default.
+func (c *Client) UseCookieJar(jar http.CookieJar) {
+	c.jar = jar
+}
+
+// withCookies returns a shallow copy of pr with cookies from the jar added,
+// pr is left untouched so that it could be retried.
+func (c *Client) withCookies(pr *http.PreparedRequest) *http.PreparedRequest {
+	cookies := c.jar.Cookies(pr.U, pr.SiteForCookies, pr.Method)
+	if len(cookies) == 0 {
+		return pr
+	}
+	values := make([]string, 0, len(cookies)+1)
+	next := *pr
+	next.Header = pr.Header.Clone()
+	for k, v := range next.Header {
+		if strings.EqualFold(k, "Cookie") {
+			// cookies set by the user go first
+			values = append(values, v...)
+			delete(next.Header, k)
+		}
+	}
+	for _, cookie := range cookies {
+		values = append(values, cookie.String())
+	}
+	next.Header.Set("Cookie", strings.Join(values, "; "))
+	return &next
+}
+
+func (c *Client) storeCookies(pr *http.PreparedRequest, resp *http.Response) {
+	if len(resp.Header["Set-Cookie"]) == 0 {
+		return
+	}
+	// reuse the parser in standard library
+	cookies := (&nethttp.Response{Header: resp.Header}).Cookies()
+	if len(cookies) != 0 {
+		c.jar.SetCookies(pr.U, pr.SiteForCookies, cookies)
+	}
+}
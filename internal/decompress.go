@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/utils/coding"
+)
+
+// DecompressionConfig controls the transparent decompression of response
+// bodies, see [Client.UseDecompression].
+type DecompressionConfig struct {
+	// Encodings are advertised in the "Accept-Encoding" request header in
+	// order of preference. default all decoders registered in package coding
+	Encodings []string
+
+	// MaxRatio bounds the ratio of decompressed size to compressed size,
+	// reading the body fails with [ErrDecompressionBomb] once exceeded.
+	// The limit applies after 1MB is decompressed. default 100, -1 disables
+	MaxRatio float64
+}
+
+// ErrDecompressionBomb is returned reading a response body whose
+// decompressed size exceeds [DecompressionConfig.MaxRatio]
+var ErrDecompressionBomb = errors.New("response body decompression ratio exceeds limit")
+
+// ratioLimitSlack is the decompressed size before the ratio limit is checked
+const ratioLimitSlack = 1 << 20
+
+func (d *DecompressionConfig) maxRatio() float64 {
+	if d.MaxRatio == 0 {
+		return 100
+	}
+	return d.MaxRatio
+}
+
+func (d *DecompressionConfig) acceptEncoding() string {
+	if len(d.Encodings) != 0 {
+		return strings.Join(d.Encodings, ", ")
+	}
+	return strings.Join(coding.Decoders(), ", ")
+}
+
+// UseDecompression makes the client advertise "Accept-Encoding" and
+// decompress response bodies accordingly. Requests with "Accept-Encoding"
+// or "Range" headers set by the user are left alone. nil disables it,
+// which is the default.
+func (c *Client) UseDecompression(cfg *DecompressionConfig) {
+	c.decompression = cfg
+}
+
+// withAcceptEncoding returns a shallow copy of pr with "Accept-Encoding" set,
+// or pr itself and false if the response should not be decompressed.
+func (c *Client) withAcceptEncoding(pr *http.PreparedRequest) (*http.PreparedRequest, bool) {
+	for k := range pr.Header {
+		switch strings.ToLower(k) {
+		case "accept-encoding", "range":
+			return pr, false
+		}
+	}
+	next := *pr
+	next.Header = pr.Header.Clone()
+	next.Header.Set("Accept-Encoding", c.decompression.acceptEncoding())
+	return &next, true
+}
+
+// decompress wraps the response body with decoders of the content codings,
+// it's left as is if any of the codings is not supported.
+func (c *Client) decompress(resp *http.Response) error {
+	var codings []string
+	for _, v := range resp.Header["Content-Encoding"] {
+		for _, enc := range strings.Split(v, ",") {
+			if enc = strings.TrimSpace(enc); enc != "" {
+				codings = append(codings, enc)
+			}
+		}
+	}
+	if len(codings) == 0 || resp.ContentLength == 0 {
+		return nil
+	}
+	for _, enc := range codings {
+		if !coding.Supported(enc) {
+			return nil
+		}
+	}
+
+	counter := &countingReader{r: resp.Body}
+	var body io.Reader = counter
+	closers := []io.Closer{resp.Body}
+	// rfc9110 8.4: codings are listed in the order they're applied
+	for i := len(codings) - 1; i >= 0; i-- {
+		dec, err := coding.NewDecoder(codings[i], body)
+		if err != nil {
+			return err
+		}
+		body = dec
+		closers = append(closers, dec)
+	}
+	resp.ContentEncoding = strings.Join(codings, ", ")
+	resp.CompressedLength = resp.ContentLength
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.Body = &decompressedBody{
+		r: body, closers: closers, resp: resp,
+		compressed: counter, maxRatio: c.decompression.maxRatio(),
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type decompressedBody struct {
+	r       io.Reader
+	closers []io.Closer // the original body first
+	resp    *http.Response
+
+	compressed *countingReader
+	n          int64
+	maxRatio   float64
+}
+
+func (d *decompressedBody) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.n += int64(n)
+	if d.maxRatio > 0 && d.n > ratioLimitSlack && float64(d.n) > float64(d.compressed.n)*d.maxRatio {
+		return n, ErrDecompressionBomb
+	}
+	if err == io.EOF {
+		d.resp.CompressedLength = d.compressed.n
+	}
+	return n, err
+}
+
+func (d *decompressedBody) Close() error {
+	for i := len(d.closers) - 1; i > 0; i-- {
+		d.closers[i].Close()
+	}
+	if d.resp.CompressedLength == -1 {
+		// the body is not fully read, the bytes received so far
+		d.resp.CompressedLength = d.compressed.n
+	}
+	return d.closers[0].Close()
+}
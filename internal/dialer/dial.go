@@ -3,16 +3,18 @@ package dialer
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"net"
-	"net/url"
 
 	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/internal/transport"
 	"github.com/frankli0324/go-http/internal/transport/http1"
 	"github.com/frankli0324/go-http/utils/netpool"
 )
 
 var schemes = map[string]string{
 	"http": "80", "https": "443", "socks": "1080",
+	"socks4": "1080", "socks4a": "1080", "socks5": "1080", "socks5h": "1080",
 }
 
 var zeroDialer net.Dialer
@@ -52,24 +54,64 @@ func (d *CoreDialer) Dial(ctx context.Context, r *http.PreparedRequest) (http.Co
 		addr, port = add, prt
 	}
 
-	var proxy string
-	if d.GetProxy != nil {
-		var err error
-		proxy, err = d.GetProxy(ctx, r.Request)
+	chains, err := d.proxyChains(ctx, r.Request)
+	if err != nil {
+		return nil, err
+	}
+	var conn http.Conn
+	for _, chain := range chains {
+		conn, err = d.connect(ctx, r, addr, port, chain)
+		// the next proxy is tried only if this one could not be reached
+		var unreachable *proxyUnreachableError
+		if err == nil || !errors.As(err, &unreachable) || ctx.Err() != nil {
+			break
+		}
+	}
+	return conn, err
+}
+
+// proxyChains returns the proxy chains to try in order, with a nil chain
+// connecting directly
+func (d *CoreDialer) proxyChains(ctx context.Context, r *http.Request) ([][]ProxyHop, error) {
+	switch {
+	case d.GetProxyChain != nil:
+		chain, err := d.GetProxyChain(ctx, r)
+		return [][]ProxyHop{chain}, err
+	case d.GetProxies != nil:
+		proxies, err := d.GetProxies(ctx, r)
 		if err != nil {
 			return nil, err
 		}
+		chains := make([][]ProxyHop, 0, len(proxies))
+		for _, proxy := range proxies {
+			if proxy == "" {
+				chains = append(chains, nil)
+			} else {
+				chains = append(chains, []ProxyHop{{URL: proxy}})
+			}
+		}
+		if len(chains) == 0 {
+			chains = append(chains, nil)
+		}
+		return chains, nil
+	case d.GetProxy != nil:
+		proxy, err := d.GetProxy(ctx, r)
+		if err != nil || proxy == "" {
+			return [][]ProxyHop{nil}, err
+		}
+		return [][]ProxyHop{{{URL: proxy}}}, nil
 	}
-	re, err := d.ConnPool.Connect(ctx, dialKey{addr, port, proxy},
+	return [][]ProxyHop{nil}, nil
+}
+
+// connect gets a connection to the host through the proxy chain
+func (d *CoreDialer) connect(ctx context.Context, r *http.PreparedRequest, addr, port string, chain []ProxyHop) (http.Conn, error) {
+	re, err := d.ConnPool.Connect(ctx, dialKey{addr, port, d.chainKey(chain)},
 		func(ctx context.Context) (netpool.Conn, error) {
 			var conn net.Conn
 			var err error
-			if proxy != "" {
-				purl, perr := url.Parse(proxy)
-				if perr != nil {
-					return nil, perr
-				}
-				conn, err = d.DialContextOverProxy(ctx, r.U, purl)
+			if len(chain) != 0 {
+				conn, err = d.DialContextOverProxyChain(ctx, r.U, chain)
 			} else {
 				conn, err = d.dialRaw(ctx, addr, port)
 			}
@@ -89,7 +131,13 @@ func (d *CoreDialer) Dial(ctx context.Context, r *http.PreparedRequest) (http.Co
 					return nil, err
 				}
 				conn = wrapTLS(c, conn)
-				// TODO: bring back http2
+				if c.ConnectionState().NegotiatedProtocol == "h2" {
+					return transport.NewH2Conn(conn, d.H2Config), nil
+				}
+			} else if d.H2CPriorKnowledge {
+				return transport.NewH2Conn(conn, d.H2Config), nil
+			} else if d.H2CUpgrade {
+				return transport.NewH2CUpgradeConn(conn, d.H2Config), nil
 			}
 			return &http1.Conn{Conn: conn}, nil
 		},
@@ -101,5 +149,6 @@ func (d *CoreDialer) Dial(ctx context.Context, r *http.PreparedRequest) (http.Co
 }
 
 type dialKey struct {
-	host, port, proxy string
+	host, port string
+	chain      string // the full proxy chain, see [CoreDialer.chainKey]
 }
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 
 	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/internal/transport/h2c"
 	"github.com/frankli0324/go-http/utils/netpool"
 )
 
@@ -22,21 +23,67 @@ type CoreDialer struct {
 
 	TLSConfig *tls.Config // the config to use
 
-	ConnPool    *netpool.PoolGroup
-	GetProxy    func(ctx context.Context, r *http.Request) (string, error)
-	ProxyConfig *ProxyConfig
+	// H2CPriorKnowledge makes http:// requests speak HTTP/2 over cleartext TCP
+	// directly, without any negotiation. rfc9113 3.3
+	H2CPriorKnowledge bool
+	// H2CUpgrade tries to upgrade http:// connections to HTTP/2 with the
+	// first request sent over it. rfc7540 3.2
+	H2CUpgrade bool
+	H2Config   *h2c.Config // options for http2 connections, could be nil
+
+	ConnPool *netpool.PoolGroup
+
+	// ProxyPool holds the connections to http/https proxies, keyed by the
+	// proxy chain up to the proxy. h2 is negotiated with https proxies if
+	// it's set, with the tunnels opened as streams over the shared
+	// connections. rfc9113 8.5
+	ProxyPool *netpool.PoolGroup
+	GetProxy  func(ctx context.Context, r *http.Request) (string, error)
+	// GetProxies returns the proxies to try in order, the next one is dialed
+	// if the connection to the previous could not be made, "" connects
+	// directly. It takes precedence over GetProxy.
+	GetProxies func(ctx context.Context, r *http.Request) ([]string, error)
+	// GetProxyChain returns the proxies to go through in order, each hop is
+	// dialed through the tunnel of the previous one. It takes precedence over
+	// GetProxy and GetProxies, an empty chain connects directly.
+	GetProxyChain func(ctx context.Context, r *http.Request) ([]ProxyHop, error)
+	ProxyConfig   *ProxyConfig
 }
 
 func (d *CoreDialer) Clone() *CoreDialer {
+	var proxyPool *netpool.PoolGroup
+	if d.ProxyPool != nil {
+		proxyPool = d.ProxyPool.NewEmpty()
+	}
 	return &CoreDialer{
 		ResolveConfig: d.ResolveConfig.Clone(),
 		TLSConfig:     d.TLSConfig.Clone(),
+
+		H2CPriorKnowledge: d.H2CPriorKnowledge,
+		H2CUpgrade:        d.H2CUpgrade,
+		H2Config:          d.H2Config.Clone(),
+
 		ConnPool:      d.ConnPool.NewEmpty(),
+		ProxyPool:     proxyPool,
 		GetProxy:      d.GetProxy,
+		GetProxies:    d.GetProxies,
+		GetProxyChain: d.GetProxyChain,
 		ProxyConfig:   d.ProxyConfig.Clone(),
 	}
 }
 
+// Close shuts down the pooled connections, see [netpool.PoolGroup.Close].
+// The dialer is still usable afterwards.
+func (d *CoreDialer) Close() error {
+	if d.ConnPool != nil {
+		d.ConnPool.Close()
+	}
+	if d.ProxyPool != nil {
+		d.ProxyPool.Close()
+	}
+	return nil
+}
+
 func (d *CoreDialer) Unwrap() Dialer {
 	return nil
 }
@@ -0,0 +1,242 @@
+package dialer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLMAuth answers NTLM challenges with NTLMv2 responses, the domain could
+// be given in the username as "DOMAIN\user". Since NTLM authenticates the
+// connection instead of the request, it only works if the proxy keeps the
+// connection open across the handshake. MS-NLMP
+type NTLMAuth struct {
+	Workstation string // sent to the proxy, could be empty
+}
+
+func (NTLMAuth) Scheme() string { return "NTLM" }
+
+func (a NTLMAuth) Authorize(ch *AuthChallenge, user *url.Userinfo, _, _ string) (string, error) {
+	if ch == nil {
+		return "", nil
+	}
+	if user == nil {
+		return "", errNoProxyCredentials
+	}
+	if ch.Token68 == "" {
+		return "NTLM " + base64.StdEncoding.EncodeToString(ntlmNegotiate()), nil
+	}
+	challenge, err := base64.StdEncoding.DecodeString(ch.Token68)
+	if err != nil {
+		return "", err
+	}
+	domain, name := "", user.Username()
+	if i := strings.IndexByte(name, '\\'); i != -1 {
+		domain, name = name[:i], name[i+1:]
+	}
+	pass, _ := user.Password()
+	var clientChallenge [8]byte
+	if _, err := rand.Read(clientChallenge[:]); err != nil {
+		return "", err
+	}
+	msg, err := ntlmAuthenticate(challenge, domain, name, pass, a.Workstation, clientChallenge[:], time.Now())
+	if err != nil {
+		return "", err
+	}
+	return "NTLM " + base64.StdEncoding.EncodeToString(msg), nil
+}
+
+const (
+	ntlmNegotiateUnicode        = 0x00000001
+	ntlmRequestTarget           = 0x00000004
+	ntlmNegotiateNTLM           = 0x00000200
+	ntlmNegotiateAlwaysSign     = 0x00008000
+	ntlmNegotiateExtendedSecure = 0x00080000
+	ntlmNegotiateTargetInfo     = 0x00800000
+	ntlmNegotiate128            = 0x20000000
+	ntlmNegotiate56             = 0x80000000
+
+	ntlmAvEOL       = 0x0000
+	ntlmAvTimestamp = 0x0007
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmNegotiate returns the NEGOTIATE_MESSAGE, without domain and workstation
+func ntlmNegotiate() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateUnicode|ntlmRequestTarget|ntlmNegotiateNTLM|
+		ntlmNegotiateAlwaysSign|ntlmNegotiateExtendedSecure|ntlmNegotiate128|ntlmNegotiate56)
+	return msg
+}
+
+// ntlmAuthenticate returns the AUTHENTICATE_MESSAGE answering the
+// CHALLENGE_MESSAGE, without MIC since the target info is sent unchanged.
+func ntlmAuthenticate(challenge []byte, domain, user, pass, workstation string, clientChallenge []byte, now time.Time) ([]byte, error) {
+	if len(challenge) < 32 || !bytes.Equal(challenge[:8], ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("ntlm: invalid challenge message")
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	serverChallenge := challenge[24:32]
+	var targetInfo []byte
+	if flags&ntlmNegotiateTargetInfo != 0 && len(challenge) >= 48 {
+		l, off := binary.LittleEndian.Uint16(challenge[40:]), binary.LittleEndian.Uint32(challenge[44:])
+		if int(off)+int(l) > len(challenge) {
+			return nil, errors.New("ntlm: invalid target info")
+		}
+		targetInfo = challenge[off : off+uint32(l)]
+	}
+
+	// the timestamp of the server is preferred, where the LMv2 response
+	// is not sent. MS-NLMP 3.1.5.1.2
+	timestamp, serverTime := ntlmFiletime(now), ntlmAvPair(targetInfo, ntlmAvTimestamp)
+	if len(serverTime) == 8 {
+		timestamp = binary.LittleEndian.Uint64(serverTime)
+	}
+	ntResponse, lmResponse := ntlmV2Response(ntowfV2(domain, user, pass), serverChallenge, clientChallenge, timestamp, targetInfo)
+	if serverTime != nil {
+		lmResponse = make([]byte, 24)
+	}
+
+	encode := func(s string) []byte {
+		if flags&ntlmNegotiateUnicode != 0 {
+			return utf16le(s)
+		}
+		return []byte(s)
+	}
+	fields := [][]byte{lmResponse, ntResponse, encode(domain), encode(user), encode(workstation), nil}
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	for i, f := range fields {
+		hdr := msg[12+8*i:]
+		binary.LittleEndian.PutUint16(hdr, uint16(len(f)))
+		binary.LittleEndian.PutUint16(hdr[2:], uint16(len(f)))
+		binary.LittleEndian.PutUint32(hdr[4:], uint32(len(msg)))
+		msg = append(msg, f...)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags)
+	return msg, nil
+}
+
+// ntowfV2 = HMAC_MD5(MD4(UNICODE(Passwd)), UNICODE(ConcatenationOf(Uppercase(User), UserDom)))
+func ntowfV2(domain, user, pass string) []byte {
+	mac := hmac.New(md5.New, md4(utf16le(pass)))
+	mac.Write(utf16le(strings.ToUpper(user) + domain))
+	return mac.Sum(nil)
+}
+
+// ntlmV2Response computes NtChallengeResponse and LmChallengeResponse.
+// MS-NLMP 3.3.2
+func ntlmV2Response(ntowf, serverChallenge, clientChallenge []byte, timestamp uint64, targetInfo []byte) (nt, lm []byte) {
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = appendUint64(temp, timestamp)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	mac := hmac.New(md5.New, ntowf)
+	mac.Write(serverChallenge)
+	mac.Write(temp)
+	nt = append(mac.Sum(nil), temp...)
+
+	mac.Reset()
+	mac.Write(serverChallenge)
+	mac.Write(clientChallenge)
+	lm = append(mac.Sum(nil), clientChallenge...)
+	return
+}
+
+// ntlmAvPair returns the value of the AV_PAIR with id, nil if not found
+func ntlmAvPair(info []byte, id uint16) []byte {
+	for len(info) >= 4 {
+		avID, l := binary.LittleEndian.Uint16(info), int(binary.LittleEndian.Uint16(info[2:]))
+		if avID == ntlmAvEOL || len(info) < 4+l {
+			break
+		}
+		if avID == id {
+			return info[4 : 4+l]
+		}
+		info = info[4+l:]
+	}
+	return nil
+}
+
+// ntlmFiletime returns the 100ns intervals since January 1, 1601 (UTC)
+func ntlmFiletime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func utf16le(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+// md4 is only used by NTLM, which is not provided by the standard library. rfc1320
+func md4(msg []byte) []byte {
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+	l := len(msg)
+	msg = append(msg[:l:l], 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = appendUint64(msg, uint64(l)*8)
+
+	var x [16]uint32
+	for ; len(msg) >= 64; msg = msg[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[4*i:])
+		}
+		aa, bb, cc, dd := a, b, c, d
+		// round 1
+		for i := 0; i < 16; i += 4 {
+			a = bits.RotateLeft32(a+(b&c|^b&d)+x[i], 3)
+			d = bits.RotateLeft32(d+(a&b|^a&c)+x[i+1], 7)
+			c = bits.RotateLeft32(c+(d&a|^d&b)+x[i+2], 11)
+			b = bits.RotateLeft32(b+(c&d|^c&a)+x[i+3], 19)
+		}
+		// round 2
+		for i := 0; i < 4; i++ {
+			a = bits.RotateLeft32(a+(b&c|b&d|c&d)+x[i]+0x5a827999, 3)
+			d = bits.RotateLeft32(d+(a&b|a&c|b&c)+x[i+4]+0x5a827999, 5)
+			c = bits.RotateLeft32(c+(d&a|d&b|a&b)+x[i+8]+0x5a827999, 9)
+			b = bits.RotateLeft32(b+(c&d|c&a|d&a)+x[i+12]+0x5a827999, 13)
+		}
+		// round 3
+		for _, i := range [4]int{0, 2, 1, 3} {
+			a = bits.RotateLeft32(a+(b^c^d)+x[i]+0x6ed9eba1, 3)
+			d = bits.RotateLeft32(d+(a^b^c)+x[i+8]+0x6ed9eba1, 9)
+			c = bits.RotateLeft32(c+(d^a^b)+x[i+4]+0x6ed9eba1, 11)
+			b = bits.RotateLeft32(b+(c^d^a)+x[i+12]+0x6ed9eba1, 15)
+		}
+		a, b, c, d = a+aa, b+bb, c+cc, d+dd
+	}
+	sum := make([]byte, 16)
+	binary.LittleEndian.PutUint32(sum, a)
+	binary.LittleEndian.PutUint32(sum[4:], b)
+	binary.LittleEndian.PutUint32(sum[8:], c)
+	binary.LittleEndian.PutUint32(sum[12:], d)
+	return sum
+}
@@ -3,22 +3,30 @@ package dialer
 import (
 	"context"
 	"crypto/tls"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"net/url"
+	"strings"
+	"sync"
 
 	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/internal/transport"
 	"github.com/frankli0324/go-http/internal/transport/http1"
+	"github.com/frankli0324/go-http/utils/netpool"
 )
 
 type ProxyConfig struct {
 	TLSConfig      *tls.Config    // the [*tls.Config] to use with proxy, if nil, *[CoreDialer.TLSConfig] will be used
 	ResolveLocally bool           // resolve the hostname though DNS before dialing through proxy
 	ResolveConfig  *ResolveConfig // overrides the resolver config for dialer for proxy
+
+	// Authenticators answer the challenges of http proxies responding with 407,
+	// using the credentials in the proxy URL. nil means [DefaultProxyAuthenticators],
+	// an empty slice disables proxy authentication.
+	Authenticators []ProxyAuthenticator
 }
 
 func (c *ProxyConfig) Clone() *ProxyConfig {
@@ -29,101 +37,334 @@ func (c *ProxyConfig) Clone() *ProxyConfig {
 		TLSConfig:      c.TLSConfig.Clone(),
 		ResolveLocally: c.ResolveLocally,
 		ResolveConfig:  c.ResolveConfig.Clone(),
+		Authenticators: c.Authenticators,
+	}
+}
+
+// ProxyHop is a proxy in a chain, see [CoreDialer.GetProxyChain]
+type ProxyHop struct {
+	URL string
+	// Config is the settings of the hop, nil uses [CoreDialer.ProxyConfig].
+	// Connections are only shared by hops with the same Config pointer, so
+	// it should be reused instead of created for each request.
+	Config *ProxyConfig
+}
+
+// hopConfig returns the settings of the hop, which could be nil
+func (d *CoreDialer) hopConfig(hop ProxyHop) *ProxyConfig {
+	if hop.Config != nil {
+		return hop.Config
 	}
+	return d.ProxyConfig
+}
+
+// chainKey identifies the proxy chain, used as part of the pool keys. The
+// settings of hops are compared by identity, so that connections made with
+// different TLS configs or credentials are never shared.
+func (d *CoreDialer) chainKey(chain []ProxyHop) string {
+	keys := make([]string, len(chain))
+	for i, hop := range chain {
+		keys[i] = hop.URL
+		if cfg := d.hopConfig(hop); cfg != nil {
+			keys[i] += fmt.Sprintf("#%p", cfg)
+		}
+	}
+	return strings.Join(keys, " ")
+}
+
+// proxyDial is a hop to dial through
+type proxyDial struct {
+	proxy *url.URL
+	cfg   *ProxyConfig
+	key   string // the chain up to the hop, the connections to the proxy are pooled with
+
+	// dial connects to the proxy, either directly or through the previous hops
+	dial func(ctx context.Context) (net.Conn, error)
 }
 
 // DialContextOverProxy creates a connection over http/socks proxy.
 // This part of logic may be reused when wrapping *[CoreDialer] into
 // a new custom [Dialer]
 func (d *CoreDialer) DialContextOverProxy(ctx context.Context, remote, proxy *url.URL) (net.Conn, error) {
-	if proxy.Scheme != "http" && proxy.Scheme != "https" { // TODO: socks
-		return nil, errors.New("unsupported proxy scheme:" + proxy.Scheme)
-	}
-	hp := proxy.Host
-	if proxy.Port() == "" {
-		hp = proxy.Hostname() + ":" + schemes[proxy.Scheme]
-	}
+	return d.DialContextOverProxyChain(ctx, remote, []ProxyHop{{URL: proxy.String()}})
+}
 
-	conn, err := zeroDialer.DialContext(ctx, "tcp", hp)
-	if err != nil {
-		return nil, err
+// DialContextOverProxyChain creates a connection through the proxies in order,
+// the tunnel through each hop carries the handshake with the next one, and
+// the last hop tunnels to remote.
+func (d *CoreDialer) DialContextOverProxyChain(ctx context.Context, remote *url.URL, chain []ProxyHop) (net.Conn, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("empty proxy chain")
 	}
-
-	if proxy.Scheme == "https" {
-		tlsCfg := d.ProxyConfig.TLSConfig
-		if tlsCfg == nil {
-			tlsCfg = d.TLSConfig
-		}
-		c := tls.Client(conn, tlsCfg)
-		if err := c.HandshakeContext(ctx); err != nil {
+	var hop *proxyDial
+	for i, h := range chain {
+		proxy, err := url.Parse(h.URL)
+		if err != nil {
 			return nil, err
 		}
-		conn = c
+		cfg := d.hopConfig(h)
+		if cfg == nil {
+			cfg = &ProxyConfig{}
+		}
+		next := &proxyDial{proxy: proxy, cfg: cfg, key: d.chainKey(chain[:i+1])}
+		if prev := hop; prev == nil {
+			hp := proxy.Host
+			if proxy.Port() == "" {
+				hp = proxy.Hostname() + ":" + schemes[proxy.Scheme]
+			}
+			next.dial = func(ctx context.Context) (net.Conn, error) {
+				conn, err := zeroDialer.DialContext(ctx, "tcp", hp)
+				if err != nil {
+					return nil, &proxyUnreachableError{proxy: hp, err: err}
+				}
+				return conn, nil
+			}
+		} else {
+			next.dial = func(ctx context.Context) (net.Conn, error) {
+				return d.dialOverProxy(ctx, proxy, prev)
+			}
+		}
+		hop = next
+	}
+	return d.dialOverProxy(ctx, remote, hop)
+}
+
+// proxyUnreachableError is returned if the connection to the first proxy
+// of a chain could not be made, see [CoreDialer.GetProxies]
+type proxyUnreachableError struct {
+	proxy string
+	err   error
+}
+
+func (e *proxyUnreachableError) Error() string {
+	return "failed to connect to proxy " + e.proxy + ": " + e.err.Error()
+}
+
+func (e *proxyUnreachableError) Unwrap() error {
+	return e.err
+}
+
+func (d *CoreDialer) dialOverProxy(ctx context.Context, remote *url.URL, hop *proxyDial) (net.Conn, error) {
+	switch hop.proxy.Scheme {
+	case "http", "https":
+		return d.dialHTTPProxy(ctx, remote, hop)
+	case "socks", "socks4", "socks4a", "socks5", "socks5h":
+		return d.dialSocks(ctx, remote, hop)
 	}
+	return nil, errors.New("unsupported proxy scheme:" + hop.proxy.Scheme)
+}
 
+// dialHTTPProxy creates a tunnel with CONNECT, answering the authentication
+// challenges of the proxy
+func (d *CoreDialer) dialHTTPProxy(ctx context.Context, remote *url.URL, hop *proxyDial) (net.Conn, error) {
+	proxy := hop.proxy
 	addr, port := remote.Host, schemes[remote.Scheme]
 	if add, prt, err := net.SplitHostPort(addr); err == nil {
 		addr, port = add, prt
 	}
+	if hop.cfg.ResolveLocally {
+		var err error
+		if addr, err = d.resolveForProxy(ctx, hop.cfg, addr, false); err != nil {
+			return nil, err
+		}
+	}
+	target := net.JoinHostPort(addr, port)
 
-	if d.ProxyConfig.ResolveLocally {
-		dnsCfg := d.ProxyConfig.ResolveConfig
-		if dnsCfg == nil {
-			dnsCfg = d.ResolveConfig
-		} else {
-			dnsCfg = dnsCfg.Merge(d.ResolveConfig)
+	// connections to the proxy are pooled, so that tunnels to different
+	// targets could share the same h2 connection as streams. http1 proxy
+	// connections become the tunnels themselves and are never reused.
+	pooled := d.ProxyPool != nil
+	dial := func(ctx context.Context) (netpool.Conn, error) {
+		conn, h2, err := d.dialProxy(ctx, hop, pooled)
+		if err != nil {
+			return nil, err
+		}
+		if h2 {
+			return transport.NewH2Conn(conn, d.H2Config), nil
 		}
+		return &http1.Conn{Conn: conn}, nil
+	}
+	connect := func() (netpool.Session, error) {
+		if pooled {
+			return d.ProxyPool.Connect(ctx, hop.key, dial)
+		}
+		return netpool.NewPool(0, 0, 0).Connect(ctx, dial)
+	}
 
-		if res, ok := dnsCfg.StaticHosts[addr]; ok {
-			addr = res
-		} else {
-			ips, err := d.lookup(ctx, dnsCfg, addr)
-			if err != nil {
+	auths := hop.cfg.authenticators()
+	authorization := preemptiveAuthorization(auths, proxy.User, target)
+	var sess netpool.Session
+	for attempt := 1; ; attempt++ {
+		if sess == nil {
+			var err error
+			if sess, err = connect(); err != nil {
 				return nil, err
 			}
-			addr = ips[rand.Intn(len(ips))].String()
 		}
+		connReq := &http.PreparedRequest{
+			Request: &http.Request{Method: "CONNECT"},
+			GetBody: func() (io.ReadCloser, error) { return http.NoBody, nil },
+		}
+		if authorization != "" {
+			connReq.Header = http.Header{"Proxy-Authorization": {authorization}}
+		}
+		resp := &http.Response{}
+		switch sess := sess.(type) {
+		case *transport.H2Session:
+			// rfc9113 8.5: only :method and :authority are sent for CONNECT
+			connReq.HeaderHost, connReq.U = target, &url.URL{Host: target}
+			if err := sess.Do(ctx, connReq, resp); err != nil {
+				return nil, err
+			}
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return sess.Tunnel(), nil
+			}
+		case *http1.Session:
+			connReq.HeaderHost, connReq.U = proxy.Host, &url.URL{Path: target}
+			if err := sess.Do(ctx, connReq, resp); err != nil {
+				sess.Release(true) // no-op if already released by the session
+				return nil, err
+			}
+			if resp.StatusCode == 200 {
+				return newHijackedTunnel(sess), nil
+			}
+		default:
+			sess.Release(true)
+			return nil, fmt.Errorf("unexpected proxy session %T", sess)
+		}
+
+		var err error
+		retry := false
+		if resp.StatusCode == 407 && attempt < maxProxyAuthAttempts {
+			prev := authorization
+			authorization, err = answerChallenges(auths, resp.Header.Values("Proxy-Authenticate"), proxy.User, target)
+			retry = err == nil && authorization != prev // the same credentials are rejected
+		}
+		if !retry {
+			s, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("proxy authentication failed: %w", err)
+			}
+			return nil, fmt.Errorf("proxy server returned error. status:%d, body:%s", resp.StatusCode, string(s))
+		}
+		// retry on the same connection if possible, which is required by
+		// the schemes authenticating the connection, e.g. NTLM
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		if h1, ok := sess.(*http1.Session); ok {
+			if next := h1.Reuse(); next != nil {
+				sess = next
+				continue
+			}
+		} else {
+			resp.Body.Close()
+		}
+		sess = nil
 	}
+}
 
-	// TODO: implement CONNECT over http2
-	// however in most cases, it seems that using a stream instead of an entire
-	// tcp connection for proxied connections is less efficient. h2 over h2 proxy
-	// maintains unnecessary state between user agent and proxy, while h1 over h2
-	// proxy is less common (which is the only sane scenario).
-	//
-	// also it's only when we also maintain agent->proxy connections in connection
-	// pools that dialing over h2 proxies is meaningful.
-	connReq := &http.PreparedRequest{
-		Request:    &http.Request{Method: "CONNECT"},
-		HeaderHost: proxy.Host,
-		U:          &url.URL{Path: addr + ":" + port},
-		GetBody:    func() (io.ReadCloser, error) { return http.NoBody, nil },
+// dialProxy connects to the http/https proxy, h2 is negotiated through ALPN
+// if allowed and [tls.Config.NextProtos] is not specified.
+func (d *CoreDialer) dialProxy(ctx context.Context, hop *proxyDial, allowH2 bool) (conn net.Conn, h2 bool, err error) {
+	conn, err = hop.dial(ctx)
+	if err != nil || hop.proxy.Scheme != "https" {
+		return conn, false, err
 	}
-	if auth := proxy.User.String(); auth != "" {
-		connReq.Header = http.Header{
-			"Proxy-Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(auth))},
+
+	tlsCfg := hop.cfg.TLSConfig
+	if tlsCfg == nil {
+		tlsCfg = d.TLSConfig
+	}
+	tlsCfg = tlsCfg.Clone()
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{}
+	}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = hop.proxy.Hostname()
+	}
+	if !allowH2 {
+		protos := tlsCfg.NextProtos[:0:0]
+		for _, p := range tlsCfg.NextProtos {
+			if p != "h2" {
+				protos = append(protos, p)
+			}
 		}
+		tlsCfg.NextProtos = protos
+	} else if len(tlsCfg.NextProtos) == 0 {
+		tlsCfg.NextProtos = []string{"h2", "http/1.1"}
 	}
-	c := &http1.Conn{Conn: conn}
-	if err := c.Setup(ctx); err != nil {
+	c := tls.Client(conn, tlsCfg)
+	if err := c.HandshakeContext(ctx); err != nil {
 		conn.Close()
-		return nil, err
+		return nil, false, err
 	}
-	session, err := c.Session(ctx, nil)
-	if err != nil {
-		conn.Close()
-		return nil, err
+	return wrapTLS(c, conn), c.ConnectionState().NegotiatedProtocol == "h2", nil
+}
+
+// hijackedTunnel is the connection to the proxy turned into a tunnel by
+// an http1 CONNECT request, it's dropped from the pool once closed.
+type hijackedTunnel struct {
+	net.Conn
+	r    io.Reader
+	sess netpool.Session
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func newHijackedTunnel(sess *http1.Session) *hijackedTunnel {
+	conn, r := sess.Hijack()
+	return &hijackedTunnel{Conn: conn, r: r, sess: sess}
+}
+
+func (t *hijackedTunnel) Read(b []byte) (int, error) {
+	return t.r.Read(b)
+}
+
+func (t *hijackedTunnel) Close() error {
+	t.closeOnce.Do(func() {
+		if _, err := t.sess.Release(true); err != nil {
+			t.closeErr = err
+		}
+	})
+	return t.closeErr
+}
+
+// resolveForProxy resolves the hostname to be sent to the proxy with
+// [ProxyConfig.ResolveConfig] merged into [CoreDialer.ResolveConfig]
+func (d *CoreDialer) resolveForProxy(ctx context.Context, cfg *ProxyConfig, host string, ipv4Only bool) (string, error) {
+	dnsCfg := cfg.ResolveConfig
+	if dnsCfg == nil {
+		dnsCfg = d.ResolveConfig
+	} else if d.ResolveConfig != nil {
+		dnsCfg = dnsCfg.Merge(d.ResolveConfig)
 	}
-	http1Session := session.(*http1.Session)
-	resp := &http.Response{}
-	if err := http1Session.Do(ctx, connReq, resp); err != nil {
-		conn.Close()
-		return nil, err
+
+	if dnsCfg != nil {
+		if res, ok := dnsCfg.StaticHosts[host]; ok {
+			host = res
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ipv4Only && ip.To4() == nil {
+			return "", errors.New("no IPv4 address for " + host)
+		}
+		return host, nil
 	}
-	if status := resp.StatusCode; status != 200 {
-		s, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		return nil, fmt.Errorf("proxy server returned error. status:%d, body:%s", status, string(s))
+	ips, err := d.lookup(ctx, dnsCfg, host)
+	if err != nil {
+		return "", err
+	}
+	if ipv4Only {
+		v4 := ips[:0:0]
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				v4 = append(v4, ip)
+			}
+		}
+		if ips = v4; len(ips) == 0 {
+			return "", errors.New("no IPv4 address for " + host)
+		}
 	}
-	return conn, nil
+	return ips[rand.Intn(len(ips))].String(), nil
 }
@@ -0,0 +1,264 @@
+package dialer
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+)
+
+// ProxyAuthenticator answers the authentication challenges of http proxies
+// in 407 (Proxy Authentication Required) responses. rfc9110 11.7.1
+type ProxyAuthenticator interface {
+	// Scheme is the auth-scheme handled, compared case-insensitively
+	Scheme() string
+	// Authorize returns the value of "Proxy-Authorization" answering ch, with
+	// the credentials taken from the proxy URL. ch is nil before any challenge
+	// is received, where an empty value sends no credentials preemptively.
+	// uri is the request-target of the CONNECT request.
+	Authorize(ch *AuthChallenge, user *url.Userinfo, method, uri string) (string, error)
+}
+
+// AuthChallenge is a challenge in "Proxy-Authenticate". rfc9110 11.3
+type AuthChallenge struct {
+	Scheme  string
+	Token68 string            // e.g. the NTLM message, could be empty
+	Params  map[string]string // auth-params, with the names lowercased
+}
+
+// DefaultProxyAuthenticators is used if [ProxyConfig.Authenticators] is nil,
+// the ones listed first are preferred if multiple challenges are received.
+var DefaultProxyAuthenticators = []ProxyAuthenticator{NTLMAuth{}, DigestAuth{}, BasicAuth{}}
+
+// maxProxyAuthAttempts bounds the CONNECT requests sent for a tunnel,
+// NTLM takes three.
+const maxProxyAuthAttempts = 4
+
+var errNoProxyCredentials = errors.New("no credentials for proxy authentication")
+
+func (c *ProxyConfig) authenticators() []ProxyAuthenticator {
+	if c == nil || c.Authenticators == nil {
+		return DefaultProxyAuthenticators
+	}
+	return c.Authenticators
+}
+
+// preemptiveAuthorization returns the credentials sent before challenged
+func preemptiveAuthorization(auths []ProxyAuthenticator, user *url.Userinfo, uri string) string {
+	for _, a := range auths {
+		if v, err := a.Authorize(nil, user, "CONNECT", uri); err == nil && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// answerChallenges picks the first authenticator matching any challenge
+// received in the "Proxy-Authenticate" fields.
+func answerChallenges(auths []ProxyAuthenticator, fields []string, user *url.Userinfo, uri string) (string, error) {
+	challenges := parseChallenges(fields)
+	for _, a := range auths {
+		for _, ch := range challenges {
+			if strings.EqualFold(a.Scheme(), ch.Scheme) {
+				return a.Authorize(ch, user, "CONNECT", uri)
+			}
+		}
+	}
+	return "", fmt.Errorf("no authenticator for proxy challenges %q", fields)
+}
+
+// parseChallenges parses the challenges in the field values, malformed
+// parts are skipped. rfc9110 11.6.1
+//
+//	challenge = auth-scheme [ 1*SP ( token68 / #auth-param ) ]
+func parseChallenges(fields []string) (challenges []*AuthChallenge) {
+	for _, s := range fields {
+		var cur *AuthChallenge
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			if s == "" {
+				break
+			}
+			tok, rest := cutToken(s)
+			if tok == "" {
+				break // malformed, drop the rest of the field
+			}
+			if after := strings.TrimLeft(rest, " \t"); cur != nil && strings.HasPrefix(after, "=") {
+				var v string
+				v, s = cutTokenOrQuoted(strings.TrimLeft(after[1:], " \t"))
+				cur.Params[strings.ToLower(tok)] = v
+				continue
+			}
+			cur = &AuthChallenge{Scheme: tok, Params: map[string]string{}}
+			challenges = append(challenges, cur)
+			s = strings.TrimLeft(rest, " \t")
+			if t68, after := cutToken68(s); t68 != "" {
+				if after = strings.TrimLeft(after, " \t"); after == "" || after[0] == ',' {
+					cur.Token68, s = t68, after
+				}
+			}
+		}
+	}
+	return
+}
+
+func isTchar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1
+}
+
+func cutToken(s string) (string, string) {
+	i := 0
+	for i < len(s) && isTchar(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// token68 = 1*( ALPHA / DIGIT / "-" / "." / "_" / "~" / "+" / "/" ) *"="
+func cutToken68(s string) (string, string) {
+	i := 0
+	for i < len(s) && (isTchar(s[i]) && strings.IndexByte("!#$%&'*^`|", s[i]) == -1 || s[i] == '/') {
+		i++
+	}
+	if i == 0 {
+		return "", s
+	}
+	for i < len(s) && s[i] == '=' {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func cutTokenOrQuoted(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		return cutToken(s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:]
+		case '\\':
+			if i+1 < len(s) {
+				i++
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), "" // unterminated
+}
+
+// BasicAuth sends the credentials preemptively. rfc7617
+type BasicAuth struct{}
+
+func (BasicAuth) Scheme() string { return "Basic" }
+
+func (BasicAuth) Authorize(_ *AuthChallenge, user *url.Userinfo, _, _ string) (string, error) {
+	if user == nil {
+		return "", errNoProxyCredentials
+	}
+	pass, _ := user.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+pass)), nil
+}
+
+// DigestAuth supports the MD5 and SHA-256 algorithms, including their
+// session variants, with qop "auth" or "auth-int". rfc7616
+type DigestAuth struct{}
+
+func (DigestAuth) Scheme() string { return "Digest" }
+
+func (DigestAuth) Authorize(ch *AuthChallenge, user *url.Userinfo, method, uri string) (string, error) {
+	if ch == nil {
+		return "", nil
+	}
+	if user == nil {
+		return "", errNoProxyCredentials
+	}
+	var cnonce [16]byte
+	if _, err := rand.Read(cnonce[:]); err != nil {
+		return "", err
+	}
+	return digestAuthorization(ch, user, method, uri, hex.EncodeToString(cnonce[:]))
+}
+
+func digestAuthorization(ch *AuthChallenge, user *url.Userinfo, method, uri, cnonce string) (string, error) {
+	p := ch.Params
+	algorithm := p["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", errors.New("unsupported digest algorithm " + algorithm)
+	}
+	h := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	var qop string
+	if p["qop"] != "" {
+		for _, q := range strings.Split(p["qop"], ",") {
+			switch q = strings.TrimSpace(q); q {
+			case "auth":
+				qop = q
+			case "auth-int":
+				if qop == "" {
+					qop = q
+				}
+			}
+		}
+		if qop == "" {
+			return "", errors.New("unsupported digest qop " + p["qop"])
+		}
+	}
+
+	pass, _ := user.Password()
+	nonce, nc := p["nonce"], "00000001"
+	ha1 := h(user.Username() + ":" + p["realm"] + ":" + pass)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+	if qop == "auth-int" {
+		ha2 = h(method + ":" + uri + ":" + h("")) // CONNECT carries no body
+	}
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	fields := []string{
+		"username=" + quote(user.Username()),
+		"realm=" + quote(p["realm"]),
+		"nonce=" + quote(nonce),
+		"uri=" + quote(uri),
+		"algorithm=" + algorithm,
+		"response=" + quote(response),
+	}
+	if opaque, ok := p["opaque"]; ok {
+		fields = append(fields, "opaque="+quote(opaque))
+	}
+	if qop != "" {
+		fields = append(fields, "qop="+qop, "nc="+nc, "cnonce="+quote(cnonce))
+	}
+	return "Digest " + strings.Join(fields, ", "), nil
+}
@@ -0,0 +1,84 @@
+package dialer
+
+import (
+	"encoding/hex"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChallenges(t *testing.T) {
+	got := parseChallenges([]string{
+		`Basic realm="a \"b\"", Digest realm="r", nonce=n, qop="auth,auth-int"`,
+		`NTLM`,
+		`Negotiate YWJj==, NTLM TlRMTVNTUAACAAAA`,
+	})
+	want := []*AuthChallenge{
+		{Scheme: "Basic", Params: map[string]string{"realm": `a "b"`}},
+		{Scheme: "Digest", Params: map[string]string{"realm": "r", "nonce": "n", "qop": "auth,auth-int"}},
+		{Scheme: "NTLM", Params: map[string]string{}},
+		{Scheme: "Negotiate", Token68: "YWJj==", Params: map[string]string{}},
+		{Scheme: "NTLM", Token68: "TlRMTVNTUAACAAAA", Params: map[string]string{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for _, c := range got {
+			t.Logf("%+v", c)
+		}
+		t.Fatal("unexpected challenges")
+	}
+}
+
+// rfc7616 3.9.1
+func TestDigestAuthorization(t *testing.T) {
+	for algorithm, response := range map[string]string{
+		"MD5":     "8ca523f5e9506fed4657c9700eebdbec",
+		"SHA-256": "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	} {
+		ch := &AuthChallenge{Scheme: "Digest", Params: map[string]string{
+			"realm":     "http-auth@example.org",
+			"qop":       "auth, auth-int",
+			"algorithm": algorithm,
+			"nonce":     "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+			"opaque":    "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+		}}
+		v, err := digestAuthorization(ch, url.UserPassword("Mufasa", "Circle of Life"),
+			"GET", "/dir/index.html", "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(v, `response="`+response+`"`) {
+			t.Errorf("%s: unexpected authorization %s", algorithm, v)
+		}
+	}
+}
+
+func TestMD4(t *testing.T) {
+	for msg, sum := range map[string]string{
+		"":    "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"abc": "a448017aaf21d8525fc10ae87aa6729d",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	} {
+		if got := hex.EncodeToString(md4([]byte(msg))); got != sum {
+			t.Errorf("md4(%q) = %s, want %s", msg, got, sum)
+		}
+	}
+}
+
+// MS-NLMP 4.2.4
+func TestNTLMv2Response(t *testing.T) {
+	ntowf := ntowfV2("Domain", "User", "Password")
+	if got := hex.EncodeToString(ntowf); got != "0c868a403bfd7a93a3001ef22ef02e3f" {
+		t.Fatalf("unexpected NTOWFv2 %s", got)
+	}
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	clientChallenge, _ := hex.DecodeString("aaaaaaaaaaaaaaaa")
+	targetInfo, _ := hex.DecodeString("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	nt, lm := ntlmV2Response(ntowf, serverChallenge, clientChallenge, 0, targetInfo)
+	if got := hex.EncodeToString(nt[:16]); got != "68cd0ab851e51c96aabc927bebef6a1c" {
+		t.Errorf("unexpected NTProofStr %s", got)
+	}
+	if got := hex.EncodeToString(lm); got != "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa" {
+		t.Errorf("unexpected LMv2 response %s", got)
+	}
+}
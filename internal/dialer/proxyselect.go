@@ -0,0 +1,244 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/frankli0324/go-http/internal/http"
+)
+
+// hostPatterns matches hosts against the patterns, see [ProxyRule.Match]
+type hostPatterns []hostPattern
+
+type hostPattern struct {
+	all     bool
+	domain  string // lowercased, without leading dot
+	subOnly bool   // domain itself is not matched
+	ip      net.IP
+	cidr    *net.IPNet
+	port    string // empty matches any port
+}
+
+func parseHostPatterns(s string) (patterns hostPatterns) {
+	for _, p := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		p = strings.ToLower(p)
+		if p == "*" {
+			patterns = append(patterns, hostPattern{all: true})
+			continue
+		}
+		var hp hostPattern
+		if _, cidr, err := net.ParseCIDR(p); err == nil {
+			hp.cidr = cidr
+			patterns = append(patterns, hp)
+			continue
+		}
+		if h, port, err := net.SplitHostPort(p); err == nil {
+			p, hp.port = h, port
+		}
+		p = strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(p, "["), "]"), ".")
+		if ip := net.ParseIP(p); ip != nil {
+			hp.ip = ip
+		} else if strings.HasPrefix(p, "*.") || strings.HasPrefix(p, ".") {
+			hp.domain, hp.subOnly = strings.TrimPrefix(strings.TrimPrefix(p, "*"), "."), true
+		} else if p != "" {
+			hp.domain = p
+		} else {
+			continue
+		}
+		patterns = append(patterns, hp)
+	}
+	return
+}
+
+// match reports whether host, which is lowercased without brackets, and
+// port matches any of the patterns
+func (ps hostPatterns) match(host, port string) bool {
+	ip := net.ParseIP(host)
+	for _, p := range ps {
+		if p.port != "" && p.port != port {
+			continue
+		}
+		switch {
+		case p.all:
+			return true
+		case p.cidr != nil:
+			if ip != nil && p.cidr.Contains(ip) {
+				return true
+			}
+		case p.ip != nil:
+			if ip != nil && p.ip.Equal(ip) {
+				return true
+			}
+		case ip == nil:
+			if host == p.domain && !p.subOnly || strings.HasSuffix(host, "."+p.domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestHost returns the lowercased host and port of the request URL
+func requestHost(r *http.Request) (u *url.URL, host, port string, err error) {
+	if u, err = url.Parse(r.URL); err != nil {
+		return nil, "", "", err
+	}
+	host, port = strings.TrimSuffix(strings.ToLower(u.Hostname()), "."), u.Port()
+	if port == "" {
+		port = schemes[u.Scheme]
+	}
+	return
+}
+
+// EnvProxyConfig selects proxies like curl does with the environment
+// variables, see [ProxyFromEnvironment].
+type EnvProxyConfig struct {
+	HTTPProxy  string // for http:// requests
+	HTTPSProxy string // for https:// requests
+	AllProxy   string // used if the one for the scheme is empty
+	NoProxy    string // the hosts connected directly, see [ProxyRule.Match] for the syntax
+}
+
+// EnvProxyConfigFromEnvironment reads the proxy variables, the lowercase ones
+// take precedence. HTTP_PROXY is ignored in CGI environments, where it could be
+// set by the "Proxy" request header (httpoxy).
+func EnvProxyConfigFromEnvironment() *EnvProxyConfig {
+	getenv := func(names ...string) string {
+		for _, n := range names {
+			if v := os.Getenv(n); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	cfg := &EnvProxyConfig{
+		HTTPProxy:  getenv("http_proxy", "HTTP_PROXY"),
+		HTTPSProxy: getenv("https_proxy", "HTTPS_PROXY"),
+		AllProxy:   getenv("all_proxy", "ALL_PROXY"),
+		NoProxy:    getenv("no_proxy", "NO_PROXY"),
+	}
+	if os.Getenv("REQUEST_METHOD") != "" {
+		cfg.HTTPProxy = os.Getenv("http_proxy")
+	}
+	return cfg
+}
+
+// GetProxy returns the [CoreDialer.GetProxy] selecting proxies by cfg,
+// proxies without a scheme are http proxies.
+func (cfg *EnvProxyConfig) GetProxy() func(ctx context.Context, r *http.Request) (string, error) {
+	noProxy := parseHostPatterns(cfg.NoProxy)
+	normalize := func(proxy string) string {
+		if proxy != "" && !strings.Contains(proxy, "://") {
+			return "http://" + proxy
+		}
+		return proxy
+	}
+	httpProxy, httpsProxy, allProxy := normalize(cfg.HTTPProxy), normalize(cfg.HTTPSProxy), normalize(cfg.AllProxy)
+	return func(ctx context.Context, r *http.Request) (string, error) {
+		u, host, port, err := requestHost(r)
+		if err != nil {
+			return "", err
+		}
+		if noProxy.match(host, port) {
+			return "", nil
+		}
+		proxy := allProxy
+		switch {
+		case u.Scheme == "http" && httpProxy != "":
+			proxy = httpProxy
+		case u.Scheme == "https" && httpsProxy != "":
+			proxy = httpsProxy
+		}
+		return proxy, nil
+	}
+}
+
+// ProxyFromEnvironment returns the [CoreDialer.GetProxy] selecting proxies
+// with HTTP_PROXY, HTTPS_PROXY, ALL_PROXY and NO_PROXY, which are read once
+// when called. See [EnvProxyConfigFromEnvironment].
+func ProxyFromEnvironment() func(ctx context.Context, r *http.Request) (string, error) {
+	return EnvProxyConfigFromEnvironment().GetProxy()
+}
+
+// ProxyRule routes the requests to the hosts matching Match through Proxy
+type ProxyRule struct {
+	// Match lists the host patterns in the syntax of NO_PROXY, separated by
+	// commas or whitespaces:
+	//
+	//	*                 every host
+	//	example.com       the domain and its subdomains
+	//	.example.com      the subdomains only, same as "*.example.com"
+	//	10.0.0.1, ::1     the IP address, brackets around IPv6 are optional
+	//	10.0.0.0/8        IP addresses in the CIDR block, hostnames are not resolved
+	//	example.com:8443  any of the above followed by a port matches that port only
+	Match string
+	// Proxy is the proxy URL, "" or "direct" connects directly
+	Proxy string
+	// Fallbacks are tried in order if Proxy could not be reached, like
+	// "PROXY a; PROXY b; DIRECT" in a PAC file. They are only used with
+	// [ProxySelector.GetProxies].
+	Fallbacks []string
+}
+
+// ProxySelector picks the proxy of the first rule matching the request,
+// like a PAC file does.
+type ProxySelector struct {
+	Rules []ProxyRule
+	// Fallback is used for the requests matching no rule, which could be
+	// another selector, e.g. [ProxyFromEnvironment]. nil connects directly.
+	Fallback func(ctx context.Context, r *http.Request) (string, error)
+}
+
+// GetProxy returns the [CoreDialer.GetProxy] selecting proxies by s,
+// the rules are parsed once when called. [ProxyRule.Fallbacks] are
+// ignored, see [ProxySelector.GetProxies].
+func (s *ProxySelector) GetProxy() func(ctx context.Context, r *http.Request) (string, error) {
+	getProxies := s.GetProxies()
+	return func(ctx context.Context, r *http.Request) (string, error) {
+		proxies, err := getProxies(ctx, r)
+		if err != nil {
+			return "", err
+		}
+		return proxies[0], nil
+	}
+}
+
+// GetProxies returns the [CoreDialer.GetProxies] selecting proxies by s,
+// the proxy of the rule matched followed by its fallbacks. The rules are
+// parsed once when called.
+func (s *ProxySelector) GetProxies() func(ctx context.Context, r *http.Request) ([]string, error) {
+	patterns := make([]hostPatterns, len(s.Rules))
+	candidates := make([][]string, len(s.Rules))
+	for i, rule := range s.Rules {
+		patterns[i] = parseHostPatterns(rule.Match)
+		for _, proxy := range append([]string{rule.Proxy}, rule.Fallbacks...) {
+			if strings.EqualFold(proxy, "direct") {
+				proxy = ""
+			}
+			candidates[i] = append(candidates[i], proxy)
+		}
+	}
+	fallback := s.Fallback
+	return func(ctx context.Context, r *http.Request) ([]string, error) {
+		_, host, port, err := requestHost(r)
+		if err != nil {
+			return nil, err
+		}
+		for i, p := range patterns {
+			if p.match(host, port) {
+				return append([]string(nil), candidates[i]...), nil
+			}
+		}
+		if fallback != nil {
+			proxy, err := fallback(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			return []string{proxy}, nil
+		}
+		return []string{""}, nil
+	}
+}
@@ -0,0 +1,103 @@
+package dialer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/frankli0324/go-http/internal/http"
+)
+
+func TestHostPatterns(t *testing.T) {
+	patterns := parseHostPatterns("example.com, .sub.test *.wild.test,10.0.0.0/8 192.168.1.1, [::1]:8080, internal.test:8443")
+	for _, cas := range []struct {
+		host, port string
+		match      bool
+	}{
+		{"example.com", "443", true},
+		{"a.example.com", "80", true},
+		{"notexample.com", "80", false},
+		{"sub.test", "80", false},
+		{"a.sub.test", "80", true},
+		{"wild.test", "80", false},
+		{"a.b.wild.test", "80", true},
+		{"10.1.2.3", "80", true},
+		{"11.1.2.3", "80", false},
+		{"192.168.1.1", "443", true},
+		{"::1", "8080", true},
+		{"::1", "80", false},
+		{"internal.test", "8443", true},
+		{"internal.test", "443", false},
+	} {
+		if got := patterns.match(cas.host, cas.port); got != cas.match {
+			t.Errorf("%s:%s matched %v, want %v", cas.host, cas.port, got, cas.match)
+		}
+	}
+	if !parseHostPatterns("*").match("anything.test", "80") {
+		t.Error("* should match every host")
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "upper.test:3128")
+	t.Setenv("http_proxy", "lower.test:3128")
+	t.Setenv("HTTPS_PROXY", "https://secure.test")
+	t.Setenv("ALL_PROXY", "socks5h://socks.test")
+	t.Setenv("NO_PROXY", "direct.test,127.0.0.0/8")
+	t.Setenv("REQUEST_METHOD", "")
+	getProxy := ProxyFromEnvironment()
+	for url, want := range map[string]string{
+		"http://example.test/":       "http://lower.test:3128",
+		"https://example.test/":      "https://secure.test",
+		"ftp://example.test/":        "socks5h://socks.test",
+		"https://a.direct.test/":     "",
+		"http://127.0.0.1:8080/path": "",
+	} {
+		got, err := getProxy(context.Background(), &http.Request{URL: url})
+		if err != nil || got != want {
+			t.Errorf("%s: got %q %v, want %q", url, got, err, want)
+		}
+	}
+}
+
+func TestProxySelector(t *testing.T) {
+	getProxy := (&ProxySelector{
+		Rules: []ProxyRule{
+			{Match: "*.corp.test, 10.0.0.0/8", Proxy: "http://corp-proxy.test:8080"},
+			{Match: "public.corp.test", Proxy: "unreachable"},
+			{Match: "localhost", Proxy: "DIRECT"},
+		},
+		Fallback: func(context.Context, *http.Request) (string, error) { return "socks5://fallback.test", nil },
+	}).GetProxy()
+	for url, want := range map[string]string{
+		"https://git.corp.test/":    "http://corp-proxy.test:8080",
+		"http://public.corp.test/":  "http://corp-proxy.test:8080", // the first rule wins
+		"http://10.1.1.1/":          "http://corp-proxy.test:8080",
+		"http://localhost:8080/":    "",
+		"https://example.test/path": "socks5://fallback.test",
+	} {
+		got, err := getProxy(context.Background(), &http.Request{URL: url})
+		if err != nil || got != want {
+			t.Errorf("%s: got %q %v, want %q", url, got, err, want)
+		}
+	}
+}
+
+func TestProxySelectorFallbacks(t *testing.T) {
+	getProxies := (&ProxySelector{
+		Rules: []ProxyRule{
+			{Match: "*.corp.test", Proxy: "http://a.test", Fallbacks: []string{"http://b.test", "DIRECT"}},
+			{Match: "localhost", Proxy: "direct"},
+		},
+	}).GetProxies()
+	for url, want := range map[string][]string{
+		"https://git.corp.test/": {"http://a.test", "http://b.test", ""},
+		"http://localhost/":      {""},
+		"https://example.test/":  {""},
+	} {
+		got, err := getProxies(context.Background(), &http.Request{URL: url})
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q %v, want %q", url, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package dialer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errSocksHostTooLong = errors.New("socks: hostname too long")
+
+// dialSocks creates a connection over socks proxy. socks4 and socks5 always
+// resolve the hostname locally, while socks4a and socks5h ("socks" is the
+// same as socks5h) send it to the proxy unless [ProxyConfig.ResolveLocally]
+// is set.
+func (d *CoreDialer) dialSocks(ctx context.Context, remote *url.URL, hop *proxyDial) (conn net.Conn, err error) {
+	proxy := hop.proxy
+	addr, port := remote.Host, schemes[remote.Scheme]
+	if add, prt, err := net.SplitHostPort(addr); err == nil {
+		addr, port = add, prt
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks: invalid port %q", port)
+	}
+
+	if hop.cfg.ResolveLocally || proxy.Scheme == "socks4" || proxy.Scheme == "socks5" {
+		// socks4 only supports IPv4 addresses
+		if addr, err = d.resolveForProxy(ctx, hop.cfg, addr, strings.HasPrefix(proxy.Scheme, "socks4")); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err = hop.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the handshake is interrupted by closing the context, the deadline is
+	// only cleared after the watcher exits, so that it's never set afterwards
+	done, ctxErr := make(chan struct{}), make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+			ctxErr <- ctx.Err()
+		case <-done:
+			ctxErr <- nil
+		}
+	}()
+	defer func() {
+		close(done)
+		if cerr := <-ctxErr; cerr != nil {
+			err = cerr
+		}
+		if err != nil {
+			conn.Close()
+			conn = nil
+		} else {
+			conn.SetDeadline(time.Time{})
+		}
+	}()
+
+	switch proxy.Scheme {
+	case "socks4", "socks4a":
+		err = socks4Connect(conn, addr, uint16(portNum), proxy.User)
+	default:
+		err = socks5Connect(conn, addr, uint16(portNum), proxy.User)
+	}
+	return conn, err
+}
+
+// socks4Connect performs the socks4 CONNECT, the hostname is sent to the
+// proxy as socks4a if addr is not an IPv4 address.
+func socks4Connect(conn net.Conn, addr string, port uint16, user *url.Userinfo) error {
+	req := []byte{4, 1, 0, 0}
+	binary.BigEndian.PutUint16(req[2:], port)
+	ip := net.ParseIP(addr).To4()
+	if ip != nil {
+		req = append(req, ip...)
+	} else {
+		req = append(req, 0, 0, 0, 1) // socks4a: 0.0.0.x indicates a hostname follows
+	}
+	if user != nil {
+		req = append(req, user.Username()...)
+	}
+	req = append(req, 0)
+	if ip == nil {
+		req = append(req, addr...)
+		req = append(req, 0)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var resp [8]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[0] != 0 {
+		return fmt.Errorf("socks4: unexpected reply version %d", resp[0])
+	}
+	switch resp[1] {
+	case 90:
+		return nil
+	case 91:
+		return errors.New("socks4: request rejected or failed")
+	case 92, 93:
+		return errors.New("socks4: request rejected by identd")
+	}
+	return fmt.Errorf("socks4: unknown reply code %d", resp[1])
+}
+
+// rfc1928 and rfc1929
+const (
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+
+	socks5AtypIPv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIPv6   = 0x04
+)
+
+var socks5Replies = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+func socks5Connect(conn net.Conn, addr string, port uint16, user *url.Userinfo) error {
+	methods := []byte{socks5AuthNone}
+	if user != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	var buf [4]byte
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != 5 {
+		return fmt.Errorf("socks5: unexpected version %d", buf[0])
+	}
+	switch buf[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if user == nil {
+			return errors.New("socks5: proxy requires authentication")
+		}
+		if err := socks5Authenticate(conn, user); err != nil {
+			return err
+		}
+	case socks5AuthNoAccept:
+		return errors.New("socks5: no acceptable authentication methods")
+	default:
+		return fmt.Errorf("socks5: unsupported authentication method %d", buf[1])
+	}
+
+	req := []byte{5, 1, 0} // CONNECT
+	if ip := net.ParseIP(addr); ip == nil {
+		if len(addr) > 255 {
+			return errSocksHostTooLong
+		}
+		req = append(req, socks5AtypDomain, byte(len(addr)))
+		req = append(req, addr...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5AtypIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5AtypIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return err
+	}
+	if buf[0] != 5 {
+		return fmt.Errorf("socks5: unexpected version %d", buf[0])
+	}
+	if rep := int(buf[1]); rep != 0 {
+		if rep < len(socks5Replies) {
+			return errors.New("socks5: " + socks5Replies[rep])
+		}
+		return fmt.Errorf("socks5: unknown reply code %d", rep)
+	}
+	// the bound address is not used, but must be consumed
+	var skip int
+	switch buf[3] {
+	case socks5AtypIPv4:
+		skip = net.IPv4len
+	case socks5AtypIPv6:
+		skip = net.IPv6len
+	case socks5AtypDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		skip = int(buf[0])
+	default:
+		return fmt.Errorf("socks5: unknown address type %d", buf[3])
+	}
+	_, err := io.CopyN(io.Discard, conn, int64(skip+2))
+	return err
+}
+
+// rfc1929 username/password authentication
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	name := user.Username()
+	pass, _ := user.Password()
+	if len(name) > 255 || len(pass) > 255 {
+		return errors.New("socks5: username or password too long")
+	}
+	req := []byte{1, byte(len(name))}
+	req = append(req, name...)
+	req = append(req, byte(len(pass)))
+	req = append(req, pass...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return errors.New("socks5: authentication failed")
+	}
+	return nil
+}
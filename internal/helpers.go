@@ -34,7 +34,8 @@ func (c *Client) DisableH2() (ok bool) {
 		}
 		for i := range np {
 			if np[i] == "h2" {
-				d.TLSConfig.NextProtos = append(np[:i], np[i+1:]...)
+				// np might be shared with the config this one is cloned from
+				d.TLSConfig.NextProtos = append(append([]string{}, np[:i]...), np[i+1:]...)
 				ok = true
 				return d
 			}
@@ -44,9 +45,36 @@ func (c *Client) DisableH2() (ok bool) {
 	return
 }
 
+// EnableH2C makes the client speak HTTP/2 to http:// URLs, either with prior
+// knowledge, or by upgrading from HTTP/1.1 with the first request.
+func (c *Client) EnableH2C(priorKnowledge bool) (ok bool) {
+	return c.UseCoreDialer(func(d *dialer.CoreDialer) dialer.Dialer {
+		d.H2CPriorKnowledge, d.H2CUpgrade = priorKnowledge, !priorKnowledge
+		return d
+	})
+}
+
 func (c *Client) UseGetProxy(getProxy func(ctx context.Context, r *http.Request) (string, error)) (ok bool) {
 	return c.UseCoreDialer(func(d *dialer.CoreDialer) dialer.Dialer {
 		d.GetProxy = getProxy
 		return d
 	})
 }
+
+// UseGetProxies routes the requests through the first proxy reachable,
+// taking precedence over [Client.UseGetProxy], see [dialer.ProxySelector.GetProxies].
+func (c *Client) UseGetProxies(getProxies func(ctx context.Context, r *http.Request) ([]string, error)) (ok bool) {
+	return c.UseCoreDialer(func(d *dialer.CoreDialer) dialer.Dialer {
+		d.GetProxies = getProxies
+		return d
+	})
+}
+
+// UseGetProxyChain routes the requests through chains of proxies, taking
+// precedence over [Client.UseGetProxy].
+func (c *Client) UseGetProxyChain(getProxyChain func(ctx context.Context, r *http.Request) ([]dialer.ProxyHop, error)) (ok bool) {
+	return c.UseCoreDialer(func(d *dialer.CoreDialer) dialer.Dialer {
+		d.GetProxyChain = getProxyChain
+		return d
+	})
+}
@@ -0,0 +1,109 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+type Severity int
+
+const (
+	SeverityInfo    Severity = iota // allowed, but worth noting
+	SeverityWarning                 // violates the rfc, while the message is still well-defined
+	SeverityError                   // the message framing or semantics is ambiguous
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// Anomaly is a deviation from the protocol found in a response
+type Anomaly struct {
+	Severity Severity
+	Section  string // the rfc section violated, e.g. "rfc9112 6.3"
+	Message  string
+	Value    string // the raw value causing the anomaly, could be empty
+
+	// Conn is the connection the response is read from, it must not be
+	// read from or written to.
+	Conn net.Conn
+}
+
+func (a Anomaly) String() string {
+	if a.Value == "" {
+		return fmt.Sprintf("%s: %s (%s)", a.Severity, a.Message, a.Section)
+	}
+	return fmt.Sprintf("%s: %s: %q (%s)", a.Severity, a.Message, a.Value, a.Section)
+}
+
+// AnomalyError is returned if an anomaly is selected by [AuditConfig.Strict]
+type AnomalyError struct {
+	Anomaly Anomaly
+}
+
+func (e *AnomalyError) Error() string {
+	return "protocol anomaly, " + e.Anomaly.String()
+}
+
+// AuditConfig receives the anomalies found in responses, see [WithAudit]
+type AuditConfig struct {
+	// Sink is called with each anomaly found, could be nil
+	Sink func(Anomaly)
+	// Strict selects the anomalies failing the request with *[AnomalyError],
+	// nil tolerates all anomalies.
+	Strict func(Anomaly) bool
+}
+
+// this type should not be used outside this file.
+type auditCtx struct {
+	context.Context
+	cfg *AuditConfig
+}
+
+var auditCtxKey = &auditCtx{} // non-nil pointer, definitely unique
+
+func (c auditCtx) Value(key interface{}) interface{} {
+	if key == auditCtxKey {
+		return c.cfg
+	}
+	return c.Context.Value(key)
+}
+
+// WithAudit returns a context, anomalies found in responses of requests
+// sent with which are reported to cfg.
+func WithAudit(ctx context.Context, cfg *AuditConfig) context.Context {
+	return auditCtx{ctx, cfg}
+}
+
+// AuditFromContext returns the config set by [WithAudit], nil if not set
+func AuditFromContext(ctx context.Context) *AuditConfig {
+	cfg, _ := ctx.Value(auditCtxKey).(*AuditConfig)
+	return cfg
+}
+
+// Report records the anomaly in [Response.Anomalies] and reports it to the
+// config set by [WithAudit], it returns *[AnomalyError] if the anomaly is
+// selected by strict mode.
+func Report(ctx context.Context, resp *Response, a Anomaly) error {
+	resp.Anomalies = append(resp.Anomalies, a)
+	cfg := AuditFromContext(ctx)
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Sink != nil {
+		cfg.Sink(a)
+	}
+	if cfg.Strict != nil && cfg.Strict(a) {
+		return &AnomalyError{a}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"io"
+	"strings"
+
+	"github.com/frankli0324/go-http/utils/coding"
+)
+
+// Compressed reports whether the body is compressed by the client,
+// see [Request.Compression].
+func (r *PreparedRequest) Compressed() bool {
+	return r.compression != ""
+}
+
+// WithoutCompression returns the request with the body not compressed,
+// it returns r itself if the body is not compressed by the client.
+func (r *PreparedRequest) WithoutCompression() *PreparedRequest {
+	if r.uncompressed == nil {
+		return r
+	}
+	return r.uncompressed
+}
+
+// compress wraps GetBody with a streaming compressor, the size of the
+// compressed body is unknown, so the body is sent in chunks in HTTP/1.1.
+func (r *PreparedRequest) compress(enc string) error {
+	if !coding.CanEncode(enc) {
+		return coding.ErrUnsupported
+	}
+	uncompressed := *r
+	uncompressed.Header = r.Header.Clone()
+	r.uncompressed = &uncompressed
+
+	getBody := r.GetBody
+	r.GetBody = func() (io.ReadCloser, error) {
+		body, err := getBody()
+		if err != nil || body == NoBody {
+			return body, err
+		}
+		return compressBody(enc, body)
+	}
+	r.ContentLength, r.compression = -1, enc
+	r.setContentEncoding()
+	return nil
+}
+
+// setContentEncoding appends the content coding applied by the client
+func (r *PreparedRequest) setContentEncoding() {
+	for k, v := range r.Header {
+		if strings.EqualFold(k, "Content-Encoding") && len(v) != 0 {
+			// the body is already encoded by the user, rfc9110 8.4:
+			// codings are listed in the order they're applied
+			r.Header[k] = []string{strings.Join(v, ", ") + ", " + r.compression}
+			return
+		}
+	}
+	r.Header.Set("Content-Encoding", r.compression)
+}
+
+func compressBody(enc string, body io.ReadCloser) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	w, err := coding.NewEncoder(enc, pw)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	go func() {
+		// stops if the reader is closed, since writes to the pipe fail
+		_, err := io.Copy(w, body)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
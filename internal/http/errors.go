@@ -0,0 +1,28 @@
+package http
+
+import "errors"
+
+// ErrNotProcessed is wrapped by errors returned from [Conn.Do] when the request
+// is known to be not processed by the server, e.g. refused by http2 GOAWAY or
+// REFUSED_STREAM. Such requests are safe to be retried on a new connection.
+var ErrNotProcessed = errors.New("request not processed by server")
+
+type notProcessedError struct {
+	error
+}
+
+func (e notProcessedError) Is(target error) bool {
+	return target == ErrNotProcessed
+}
+
+func (e notProcessedError) Unwrap() error {
+	return e.error
+}
+
+// NotProcessed wraps err so that errors.Is(err, [ErrNotProcessed]) holds
+func NotProcessed(err error) error {
+	if err == nil {
+		return nil
+	}
+	return notProcessedError{err}
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExpectsContinue reports whether the request carries "Expect: 100-continue",
+// in which case the body is held until the server asks for it. rfc9110 10.1.1
+func (r *PreparedRequest) ExpectsContinue() bool {
+	for _, v := range r.Header.Values("Expect") {
+		if strings.EqualFold(strings.TrimSpace(v), "100-continue") {
+			return true
+		}
+	}
+	return false
+}
+
+// this type should not be used outside this file.
+type expectContinueCtx struct {
+	context.Context
+	timeout time.Duration
+}
+
+var expectContinueCtxKey = &expectContinueCtx{} // non-nil pointer, definitely unique
+
+func (c expectContinueCtx) Value(key interface{}) interface{} {
+	if key == expectContinueCtxKey {
+		return c.timeout
+	}
+	return c.Context.Value(key)
+}
+
+// WithExpectContinueTimeout returns a context, requests with
+// "Expect: 100-continue" sent with which wait at most d for the interim
+// response before sending the body anyway. default 1 second
+func WithExpectContinueTimeout(ctx context.Context, d time.Duration) context.Context {
+	return expectContinueCtx{ctx, d}
+}
+
+func expectContinueTimeout(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(expectContinueCtxKey).(time.Duration); ok && d > 0 {
+		return d
+	}
+	return time.Second
+}
+
+// ContinueGate holds the request body of a request expecting 100-continue
+// until the response reader decides whether the body should be sent.
+type ContinueGate struct {
+	once sync.Once
+	ch   chan bool
+}
+
+func NewContinueGate() *ContinueGate {
+	return &ContinueGate{ch: make(chan bool, 1)}
+}
+
+// Continue is called by the response reader, send is true if a 100 (Continue)
+// is received, false if a final response is received before the body is
+// sent. Only the first call counts, whose caller gets true.
+func (g *ContinueGate) Continue(send bool) (decided bool) {
+	g.once.Do(func() { g.ch <- send; decided = true })
+	return
+}
+
+// Wait blocks until [ContinueGate.Continue] is called or the timeout set
+// by [WithExpectContinueTimeout] expires, it returns whether the body
+// should be sent.
+func (g *ContinueGate) Wait(ctx context.Context) (bool, error) {
+	timer := time.NewTimer(expectContinueTimeout(ctx))
+	defer timer.Stop()
+	select {
+	case send := <-g.ch:
+		return send, nil
+	case <-timer.C:
+		// rfc9110 10.1.1: the client may send the body without waiting indefinitely
+		g.Continue(true)
+		return <-g.ch, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// InterimResponseHandler is called with the status code and headers of each
+// informational (1xx) response preceding the final response, e.g.
+// 103 (Early Hints). Returning an error aborts the request.
+type InterimResponseHandler func(code int, header http.Header) error
+
+// this type should not be used outside this file.
+type interimHandlerCtx struct {
+	context.Context
+	h InterimResponseHandler
+}
+
+var interimHandlerCtxKey = &interimHandlerCtx{} // non-nil pointer, definitely unique
+
+func (c interimHandlerCtx) Value(key interface{}) interface{} {
+	if key == interimHandlerCtxKey {
+		return c.h
+	}
+	return c.Context.Value(key)
+}
+
+// WithInterimResponseHandler returns a context, requests sent with which
+// report their interim responses to h.
+func WithInterimResponseHandler(ctx context.Context, h InterimResponseHandler) context.Context {
+	return interimHandlerCtx{ctx, h}
+}
+
+// IsInterim reports whether the status code is of an interim response,
+// which is followed by the final one. 101 (Switching Protocols) is final
+// for the current protocol. rfc9110 15.2
+func IsInterim(code int) bool {
+	return code >= 100 && code < 200 && code != 101
+}
+
+// MaxInterimResponses bounds the interim responses before the final response
+const MaxInterimResponses = 16
+
+var ErrTooManyInterimResponses = errors.New("too many interim responses")
+
+// HandleInterim reports the n-th (counting from 1) interim response to the
+// handler set by [WithInterimResponseHandler].
+func HandleInterim(ctx context.Context, n, code int, header http.Header) error {
+	if n > MaxInterimResponses {
+		return ErrTooManyInterimResponses
+	}
+	if h, _ := ctx.Value(interimHandlerCtxKey).(InterimResponseHandler); h != nil {
+		return h(code, header)
+	}
+	return nil
+}
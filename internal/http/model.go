@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"net/url"
 )
 
 // Request is an object holding minimal information a request contains.
@@ -15,6 +16,51 @@ type Request struct {
 	URL    string
 	Body   interface{}
 	Header http.Header
+
+	// HeaderOrder lists the field names, case-insensitive, in the order they
+	// are written, which could include the fields generated by the transports:
+	// "Host", "Content-Length", "Transfer-Encoding", "Trailer" for http1, and
+	// the pseudo-header fields ":method", ":authority", ":scheme", ":path" for
+	// http2, where pseudo-header fields always precede the others (rfc9113 8.3).
+	// Fields not listed follow those listed, the generated ones first, then the
+	// others sorted by name. Fields in Header named after a generated one are
+	// replaced by the generated value instead of written twice.
+	HeaderOrder []string
+
+	// Compression is the content coding, e.g. "gzip" or "zstd", the body is compressed
+	// with on the fly, "Content-Encoding" is set accordingly. The request is
+	// retried without compression if the server responds with 415
+	// (Unsupported Media Type). Codings are provided by package utils/coding.
+	Compression string
+
+	// Trailer holds the header fields sent after the request body, the keys
+	// are declared in the "Trailer" header before the body is written, while
+	// the values are read after the body is fully written.
+	Trailer http.Header
+
+	// Raw is written as is instead of the request built from the fields
+	// above, only URL is used to tell where it's sent. The response is parsed
+	// leniently, with the deviations reported in [Response.Anomalies].
+	// Only supported over http1.
+	Raw *RawRequest
+}
+
+// RawRequest is an http1 request written byte by byte as given, without
+// any validation or normalization, e.g. for testing how servers and proxies
+// handle malformed requests.
+type RawRequest struct {
+	// RequestLine is written first, e.g. "GET / HTTP/1.1"
+	RequestLine string
+	// Lines are the header field lines in order. Each line, as well as the
+	// request line, is terminated by CRLF, unless it already ends with "\n",
+	// so that bare LF could be sent. obs-fold could be sent as a line
+	// starting with whitespace.
+	Lines []string
+	// HeaderEnd terminates the header section. default "\r\n"
+	HeaderEnd string
+	// Body is written after the header section as is, whatever the framing
+	// the header fields declare.
+	Body []byte
 }
 
 type Response struct {
@@ -23,10 +69,52 @@ type Response struct {
 	StatusCode int
 	Header     http.Header
 
+	// RawHeader holds the header fields exactly as received, keeping the
+	// case of names, their order and repetitions. It's only set if requested
+	// with [WithRawHeader].
+	RawHeader []HeaderField
+
 	ContentLength    int64
 	TransferEncoding string
 
+	// ContentEncoding is the original "Content-Encoding" header if the body
+	// is transparently decompressed, with CompressedLength being the original
+	// "Content-Length". Both headers are removed from Header in that case.
+	// Without "Content-Length", CompressedLength is -1 until the body returns
+	// io.EOF or is closed, when it's set to the compressed bytes received.
+	ContentEncoding  string
+	CompressedLength int64
+
 	Body io.ReadCloser
+
+	// Trailer holds the header fields sent by the server after the
+	// response body, it's only available after Body returns io.EOF.
+	Trailer http.Header
+
+	// URL is where the response is received from, which differs from
+	// the request URL if redirects are followed
+	URL string
+	// Redirects holds the redirect responses followed before this one
+	// in order, their bodies are already closed
+	Redirects []*Response
+
+	// Anomalies holds the deviations from the protocol found while parsing
+	// the response, which are tolerated unless selected by strict mode, see
+	// [WithAudit]. More are tolerated for [Request.Raw].
+	Anomalies []Anomaly
+}
+
+// HeaderField is a single field line of a message header
+type HeaderField struct {
+	Name, Value string
+}
+
+// CookieJar stores cookies received in responses and provides the cookies
+// to send in requests. site is the URL of the first request in a redirect
+// chain, used to tell whether the request is same-site (rfc6265bis 5.2).
+type CookieJar interface {
+	Cookies(u, site *url.URL, method string) []*http.Cookie
+	SetCookies(u, site *url.URL, cookies []*http.Cookie)
 }
 
 type Conn interface {
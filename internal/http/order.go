@@ -0,0 +1,52 @@
+package http
+
+import (
+	"sort"
+	"strings"
+)
+
+// HeaderKeys returns the field names to write in order, which are the keys of
+// r.Header together with generated, the fields generated by the transport
+// like "Host" and ":path". Keys of r.Header matching a generated field, e.g.
+// "Transfer-Encoding" set by the user for a chunked body, are dropped, so the
+// field is only written once with the generated value, still placed where
+// [Request.HeaderOrder] lists the name. Fields listed in [Request.HeaderOrder]
+// come first in that order, then the generated fields not listed in their
+// given order, then the rest sorted, so that the order on the wire is deterministic.
+func (r *PreparedRequest) HeaderKeys(generated ...string) []string {
+	keys := make([]string, 0, len(r.Header)+len(generated))
+	keys = append(keys, generated...)
+	rest := make([]string, 0, len(r.Header))
+	for k := range r.Header {
+		if !containsFold(generated, k) {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	keys = append(keys, rest...)
+
+	if len(r.HeaderOrder) == 0 {
+		return keys
+	}
+	rank := func(k string) int {
+		for i, o := range r.HeaderOrder {
+			if strings.EqualFold(o, k) {
+				return i
+			}
+		}
+		return len(r.HeaderOrder)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return rank(keys[i]) < rank(keys[j])
+	})
+	return keys
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
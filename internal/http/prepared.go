@@ -23,6 +23,21 @@ type PreparedRequest struct {
 	ContentLength int64
 
 	Written bool // set to true after Host header is written
+
+	// SiteForCookies is the URL of the first request in the redirect chain,
+	// which is the request itself if not redirected
+	SiteForCookies *url.URL
+
+	oneShot bool // GetBody could only be called once
+
+	compression  string           // content coding applied to the body by GetBody
+	uncompressed *PreparedRequest // see [PreparedRequest.WithoutCompression]
+}
+
+// Replayable reports whether [PreparedRequest.GetBody] could be called
+// multiple times, which is required when retrying the request.
+func (r *PreparedRequest) Replayable() bool {
+	return !r.oneShot
 }
 
 func (r *Request) Prepare() (*PreparedRequest, error) {
@@ -30,11 +45,44 @@ func (r *Request) Prepare() (*PreparedRequest, error) {
 	if err != nil {
 		return nil, err
 	}
+	if r.Raw != nil {
+		return r.prepareRaw(u)
+	}
+
+	headers, host, cl := splitHeader(r.Header, u.Host)
+	if host == "" {
+		return nil, url.InvalidHostError("empty host")
+	}
 
-	headers := r.Header.Clone()
-	host := u.Host
+	pr := &PreparedRequest{
+		Request: r, U: u,
+		Header: headers, HeaderHost: host,
+		ContentLength:  cl,
+		SiteForCookies: u,
+	}
+	if err := pr.updateBody(); err != nil {
+		// note that updateBody potentially updates content-length
+		return nil, err
+	}
+	if cl != -1 && pr.ContentLength != cl {
+		return nil, errors.New("conflicting value between body size and content-length request header")
+	}
+	if r.Compression != "" && r.Body != nil {
+		if err := pr.compress(r.Compression); err != nil {
+			return nil, err
+		}
+	}
+	return pr, nil
+}
+
+// splitHeader clones h without the Host and Content-Length fields,
+// which are returned separately. user defined headers has higher priority
+func splitHeader(h http.Header, host string) (http.Header, string, int64) {
+	headers := h.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
 	cl := int64(-1)
-	// user defined headers has higher priority
 	for k, v := range headers {
 		if strings.ToLower(k) == "host" {
 			if len(v) != 0 { // && !httpguts.ValidHostHeader(host)
@@ -52,23 +100,75 @@ func (r *Request) Prepare() (*PreparedRequest, error) {
 			delete(headers, k)
 		}
 	}
-	if host == "" {
+	return headers, host, cl
+}
+
+// prepareRaw skips all normalization, the request is written as is
+func (r *Request) prepareRaw(u *url.URL) (*PreparedRequest, error) {
+	if u.Host == "" {
 		return nil, url.InvalidHostError("empty host")
 	}
-
-	pr := &PreparedRequest{
+	body := r.Raw.Body
+	return &PreparedRequest{
 		Request: r, U: u,
-		Header: headers, HeaderHost: host,
-		ContentLength: cl,
+		GetBody: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		},
+		Header: make(http.Header), HeaderHost: u.Host,
+		ContentLength:  int64(len(body)),
+		SiteForCookies: u,
+	}, nil
+}
+
+// Redirect prepares the request following a redirect to u with method,
+// the body is replayed with [PreparedRequest.GetBody] if keepBody is set,
+// or dropped together with the headers describing it.
+// Request of the result holds a copy of the headers to be sent, changes to
+// it are applied with [PreparedRequest.UpdateHeader].
+func (r *PreparedRequest) Redirect(u *url.URL, method string, keepBody bool) *PreparedRequest {
+	req := *r.Request
+	req.Method, req.URL = method, u.String()
+	next := &PreparedRequest{
+		Request: &req, U: u,
+		GetBody: r.GetBody, Header: r.Header.Clone(), HeaderHost: u.Host,
+		ContentLength:  r.ContentLength,
+		SiteForCookies: r.SiteForCookies,
+		oneShot:        r.oneShot,
+		compression:    r.compression,
 	}
-	if err := pr.updateBody(); err != nil {
-		// note that updateBody potentially updates content-length
-		return nil, err
+	if r.uncompressed != nil {
+		next.uncompressed = r.uncompressed.Redirect(u, method, keepBody)
 	}
-	if cl != -1 && pr.ContentLength != cl {
-		return nil, errors.New("conflicting value between body size and content-length request header")
+	if !keepBody {
+		next.compression, next.uncompressed = "", nil
+		next.GetBody = func() (io.ReadCloser, error) {
+			return http.NoBody, nil
+		}
+		next.ContentLength, next.oneShot, req.Body, req.Trailer = -1, false, nil, nil
+		for k := range next.Header {
+			switch strings.ToLower(k) {
+			case "content-type", "content-encoding", "content-language", "content-location", "transfer-encoding":
+				delete(next.Header, k)
+			}
+		}
+	}
+	if next.uncompressed != nil {
+		req.Header = next.uncompressed.Header.Clone()
+	} else {
+		req.Header = next.Header.Clone()
+	}
+	return next
+}
+
+// UpdateHeader replaces the headers to be sent with those of Request,
+// e.g. after modified by the redirect policy.
+func (r *PreparedRequest) UpdateHeader() {
+	r.Header, r.HeaderHost, _ = splitHeader(r.Request.Header, r.U.Host)
+	if r.uncompressed != nil {
+		r.uncompressed.Request.Header = r.Request.Header
+		r.uncompressed.Header, r.uncompressed.HeaderHost = r.Header.Clone(), r.HeaderHost
+		r.setContentEncoding()
 	}
-	return pr, nil
 }
 
 // should only be called once at [Prepare]
@@ -110,23 +210,51 @@ func (r *PreparedRequest) updateBody() (err error) {
 			r := snapshot
 			return io.NopCloser(&r), nil
 		}
-	case io.Reader:
-		if sizer, ok := b.(interface{ Size() int64 }); ok {
-			r.ContentLength = sizer.Size()
+	case io.ReadSeeker:
+		if _, ok := b.(io.Closer); ok {
+			// the body is owned and closed by the request, which can't be replayed
+			return r.updateOneShotBody(b)
+		}
+		// replay by seeking back to where the body starts
+		start, err := b.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
 		}
-		cb, ok := b.(io.ReadCloser)
-		if !ok {
-			cb = io.NopCloser(b)
+		if sizer, ok := b.(interface{ Size() int64 }); ok {
+			r.ContentLength = sizer.Size() - start
+		} else if end, err := b.Seek(0, io.SeekEnd); err == nil {
+			r.ContentLength = end - start
 		}
-		once := uint32(0)
 		r.GetBody = func() (io.ReadCloser, error) {
-			if atomic.CompareAndSwapUint32(&once, 0, 1) {
-				return cb, nil
+			if _, err := b.Seek(start, io.SeekStart); err != nil {
+				return nil, err
 			}
-			return nil, http.ErrBodyReadAfterClose
+			return io.NopCloser(b), nil
 		}
+	case io.Reader:
+		return r.updateOneShotBody(b)
 	default:
 		return fmt.Errorf("unsupported body type: %T", r.Request.Body)
 	}
 	return nil
 }
+
+// updateOneShotBody sets a body that could only be read once
+func (r *PreparedRequest) updateOneShotBody(b io.Reader) error {
+	r.oneShot = true
+	if sizer, ok := b.(interface{ Size() int64 }); ok {
+		r.ContentLength = sizer.Size()
+	}
+	cb, ok := b.(io.ReadCloser)
+	if !ok {
+		cb = io.NopCloser(b)
+	}
+	once := uint32(0)
+	r.GetBody = func() (io.ReadCloser, error) {
+		if atomic.CompareAndSwapUint32(&once, 0, 1) {
+			return cb, nil
+		}
+		return nil, http.ErrBodyReadAfterClose
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package http
+
+import "context"
+
+// this type should not be used outside this file.
+type rawHeaderCtx struct {
+	context.Context
+}
+
+var rawHeaderCtxKey = &rawHeaderCtx{} // non-nil pointer, definitely unique
+
+func (c rawHeaderCtx) Value(key interface{}) interface{} {
+	if key == rawHeaderCtxKey {
+		return true
+	}
+	return c.Context.Value(key)
+}
+
+// WithRawHeader returns a context, responses of requests sent with which
+// have [Response.RawHeader] set.
+func WithRawHeader(ctx context.Context) context.Context {
+	return rawHeaderCtx{ctx}
+}
+
+// KeepRawHeader reports whether the context is returned by [WithRawHeader]
+func KeepRawHeader(ctx context.Context) bool {
+	keep, _ := ctx.Value(rawHeaderCtxKey).(bool)
+	return keep
+}
@@ -16,10 +16,13 @@ var defaultDialer = &dialer.CoreDialer{
 		NextProtos: []string{"h2", "http/1.1"},
 	},
 	ProxyConfig: &dialer.ProxyConfig{
-		TLSConfig:      &tls.Config{}, // don't want h2
+		TLSConfig:      &tls.Config{}, // h2 is negotiated since ProxyPool is set
 		ResolveLocally: false,
 	},
 	ConnPool: netpool.NewGroup(100, 100, 90*time.Second),
+	// not limited, tunnels over http1 proxies take a connection each, which
+	// might be held by the idle connections in ConnPool
+	ProxyPool: netpool.NewGroup(0, 0, 90*time.Second),
 }
 
 func getRawConn(c io.ReadWriteCloser) net.Conn {
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/frankli0324/go-http/internal/http"
+)
+
+// RedirectPolicy controls how redirects are followed by [Client],
+// redirects are not followed unless a policy is set with [Client.UseRedirect].
+type RedirectPolicy struct {
+	// MaxHops is the maximum number of redirects followed for a request,
+	// an error is returned if exceeded. default 10
+	MaxHops int
+
+	// CheckRedirect is called before following each redirect with the next
+	// request and the redirect responses received so far, the oldest first.
+	// Changes to the headers of next are sent, credentials are already
+	// removed from them if redirected to another origin.
+	// Returning [ErrUseLastResponse] stops following and returns the last
+	// response with its body unclosed, other errors fail the request.
+	CheckRedirect func(next *http.Request, via []*http.Response) error
+}
+
+// ErrUseLastResponse could be returned by [RedirectPolicy.CheckRedirect]
+// to stop following redirects without failing the request.
+var ErrUseLastResponse = errors.New("use last response")
+
+func (p *RedirectPolicy) maxHops() int {
+	if p.MaxHops == 0 {
+		return 10
+	}
+	return p.MaxHops
+}
+
+// UseRedirect sets the policy of following redirects, nil disables it
+func (c *Client) UseRedirect(policy *RedirectPolicy) {
+	c.redirect = policy
+}
+
+// redirectMethod returns the method of the request following the redirect,
+// and whether the body should be kept. rfc9110 15.4
+func redirectMethod(code int, method string) (string, bool, bool) {
+	switch code {
+	case 301, 302:
+		// for historical reasons, a user agent MAY change the request method
+		// from POST to GET for the subsequent request.
+		if method == "POST" {
+			return "GET", false, true
+		}
+		return method, true, true
+	case 303:
+		// a user agent can perform a retrieval request targeting that URI
+		// (a GET or HEAD request if using HTTP)
+		if method == "HEAD" {
+			return method, false, true
+		}
+		return "GET", false, true
+	case 307, 308:
+		// the user agent MUST NOT change the request method
+		return method, true, true
+	}
+	return "", false, false
+}
+
+// maxDrainBody bounds the body of discarded responses read before
+// closing, e.g. redirects, so that the connection could be reused
+const maxDrainBody = 2 << 10
+
+func (c *Client) followRedirects(ctx context.Context, pr *http.PreparedRequest, resp *http.Response) (*http.Response, error) {
+	var via []*http.Response
+	for {
+		method, keepBody, ok := redirectMethod(resp.StatusCode, pr.Method)
+		loc := resp.Header.Get("Location")
+		if !ok || loc == "" {
+			break
+		}
+		u, err := pr.U.Parse(loc)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to parse Location header %q: %w", loc, err)
+		}
+		if keepBody && !pr.Replayable() {
+			break // body already consumed, the redirect response is returned as is
+		}
+		if len(via) >= c.redirect.maxHops() {
+			resp.Body.Close()
+			return nil, fmt.Errorf("stopped after %d redirects", len(via))
+		}
+
+		next := pr.Redirect(u, method, keepBody)
+		if !sameOrigin(pr, next) {
+			// credentials are not sent to other origins
+			for k := range next.Request.Header {
+				switch strings.ToLower(k) {
+				case "authorization", "cookie", "cookie2":
+					delete(next.Request.Header, k)
+				}
+			}
+		}
+		if check := c.redirect.CheckRedirect; check != nil {
+			if err := check(next.Request, append(via, resp)); err != nil {
+				if err == ErrUseLastResponse {
+					break
+				}
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		next.UpdateHeader()
+
+		io.CopyN(io.Discard, resp.Body, maxDrainBody)
+		resp.Body.Close()
+		via = append(via, resp)
+
+		if resp, pr, err = c.roundTrip(ctx, next); err != nil {
+			return nil, err
+		}
+	}
+	resp.Redirects = via
+	return resp, nil
+}
+
+// rfc6454 4: origin is the scheme, host and port of the URL
+func sameOrigin(a, b *http.PreparedRequest) bool {
+	return strings.EqualFold(a.U.Scheme, b.U.Scheme) && strings.EqualFold(a.U.Hostname(), b.U.Hostname()) &&
+		originPort(a) == originPort(b)
+}
+
+func originPort(r *http.PreparedRequest) string {
+	if port := r.U.Port(); port != "" {
+		return port
+	}
+	if strings.EqualFold(r.U.Scheme, "https") {
+		return "443"
+	}
+	return "80"
+}
@@ -0,0 +1,130 @@
+package chunked
+
+import (
+	"context"
+	"errors"
+)
+
+// ExtensionHandler is called with each chunk extension in the order they
+// appear, size is the size of the chunk carrying the extension, which is
+// zero for the last chunk. Returning an error aborts reading the body.
+type ExtensionHandler func(size int64, name, value string) error
+
+// this type should not be used outside this file.
+type extensionHandlerCtx struct {
+	context.Context
+	h ExtensionHandler
+}
+
+var extensionHandlerCtxKey = &extensionHandlerCtx{} // non-nil pointer, definitely unique
+
+func (c extensionHandlerCtx) Value(key interface{}) interface{} {
+	if key == extensionHandlerCtxKey {
+		return c.h
+	}
+	return c.Context.Value(key)
+}
+
+// WithExtensionHandler returns a context, requests sent with which report
+// the chunk extensions of chunked response bodies to h.
+func WithExtensionHandler(ctx context.Context, h ExtensionHandler) context.Context {
+	return extensionHandlerCtx{ctx, h}
+}
+
+// ExtensionHandlerFromContext returns the handler set by [WithExtensionHandler]
+func ExtensionHandlerFromContext(ctx context.Context) ExtensionHandler {
+	h, _ := ctx.Value(extensionHandlerCtxKey).(ExtensionHandler)
+	return h
+}
+
+var errMalformedExtension = errors.New("malformed chunk extension")
+
+// parseChunkExtensions parses the chunk extensions after chunk-size. rfc9112 7.1.1
+//
+//	chunk-ext      = *( BWS ";" BWS chunk-ext-name
+//	                    [ BWS "=" BWS chunk-ext-val ] )
+//	chunk-ext-name = token
+//	chunk-ext-val  = token / quoted-string
+func parseChunkExtensions(ext []byte, cb func(name, value string) error) error {
+	i := skipBWS(ext, 0)
+	for i < len(ext) {
+		if ext[i] != ';' {
+			return errMalformedExtension
+		}
+		i = skipBWS(ext, i+1)
+		start := i
+		for i < len(ext) && isTokenChar(ext[i]) {
+			i++
+		}
+		if start == i {
+			return errMalformedExtension
+		}
+		name, value := string(ext[start:i]), ""
+		i = skipBWS(ext, i)
+		if i < len(ext) && ext[i] == '=' {
+			i = skipBWS(ext, i+1)
+			var ok bool
+			if value, i, ok = readExtensionValue(ext, i); !ok {
+				return errMalformedExtension
+			}
+			i = skipBWS(ext, i)
+		}
+		if err := cb(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readExtensionValue(ext []byte, i int) (value string, next int, ok bool) {
+	if i < len(ext) && ext[i] == '"' {
+		// rfc9110 5.6.4: quoted-string with quoted-pair
+		buf := make([]byte, 0, len(ext)-i)
+		for i++; i < len(ext); i++ {
+			switch b := ext[i]; {
+			case b == '"':
+				return string(buf), i + 1, true
+			case b == '\\':
+				if i++; i == len(ext) {
+					return "", i, false
+				}
+				buf = append(buf, ext[i])
+			case b == '\t' || b >= ' ' && b != 0x7f:
+				buf = append(buf, b)
+			default:
+				return "", i, false
+			}
+		}
+		return "", i, false // unterminated
+	}
+	start := i
+	for i < len(ext) && isTokenChar(ext[i]) {
+		i++
+	}
+	return string(ext[start:i]), i, start != i
+}
+
+// chunk-size is followed by BWS or ";"
+func isExtensionStart(b byte) bool {
+	return b == ';' || b == ' ' || b == '\t'
+}
+
+func skipBWS(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// rfc9110 5.6.2: tchar
+func isTokenChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	switch b {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~':
+		return true
+	}
+	return false
+}
@@ -4,58 +4,119 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"net/http"
+	"net/textproto"
 )
 
-func NewChunkedReader(r io.Reader) io.Reader {
+func NewChunkedReader(r io.Reader) *chunkedReader {
 	var br *bufio.Reader
 	if v, ok := r.(*bufio.Reader); ok {
 		br = v
 	} else {
 		br = bufio.NewReader(r)
 	}
-	return &chunkedReader{br, 0, -1}
+	return &chunkedReader{r: br, currentChunkSize: -1}
 }
 
 type chunkedReader struct {
-	*bufio.Reader
+	r                              *bufio.Reader // not embedded, or ReadByte would bypass chunk decoding
 	currentCount, currentChunkSize int64
+
+	sawEOF  bool
+	trailer http.Header
+
+	onExtension ExtensionHandler
 }
 
-func (c *chunkedReader) readChunkHeader() (len uint64, err error) {
-	cnt := 0
+// OnExtension registers the handler called with each chunk extension
+func (c *chunkedReader) OnExtension(h ExtensionHandler) {
+	c.onExtension = h
+}
+
+// Trailer returns the trailer fields after the last chunk,
+// it's nil until the reader returns io.EOF, or if there's no trailer.
+func (c *chunkedReader) Trailer() http.Header {
+	return c.trailer
+}
+
+// readTrailer reads the trailer section after the last chunk. rfc9112 7.1.2
+func (c *chunkedReader) readTrailer() error {
+	trailer, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if len(trailer) != 0 {
+		c.trailer = http.Header(trailer)
+	}
+	return nil
+}
+
+// maxChunkHeaderLength bounds the chunk-size line including chunk extensions,
+// same as the limit in net/http
+const maxChunkHeaderLength = 4096
+
+// readChunkHeader reads the chunk-size line. rfc9112 7.1
+//
+//	chunk-size [ chunk-ext ] CRLF
+func (c *chunkedReader) readChunkHeader() (size uint64, err error) {
+	var header []byte
 	isPref := true
 	for isPref {
 		var line []byte
-		line, isPref, err = c.ReadLine()
+		line, isPref, err = c.r.ReadLine()
 		if err != nil {
 			if err == io.EOF {
 				err = io.ErrUnexpectedEOF
 			}
 			return 0, err
 		}
-		for _, b := range line {
-			cnt++
-			switch {
-			case '0' <= b && b <= '9':
-				b = b - '0'
-			case 'a' <= b && b <= 'f':
-				b = b - 'a' + 10
-			case 'A' <= b && b <= 'F':
-				b = b - 'A' + 10
-			default:
-				return 0, errors.New("invalid byte in chunk length")
-			}
-			len <<= 4
-			len |= uint64(b)
+		if header == nil && !isPref {
+			header = line // not copied, the whole line is in buffer
+		} else {
+			header = append(header, line...)
+		}
+		if len(header) > maxChunkHeaderLength {
+			return 0, errors.New("http chunk header too long")
 		}
-		if cnt >= 16 {
+	}
+	cnt := 0
+	for ; cnt < len(header) && !isExtensionStart(header[cnt]); cnt++ {
+		b := header[cnt]
+		switch {
+		case '0' <= b && b <= '9':
+			b = b - '0'
+		case 'a' <= b && b <= 'f':
+			b = b - 'a' + 10
+		case 'A' <= b && b <= 'F':
+			b = b - 'A' + 10
+		default:
+			return 0, errors.New("invalid byte in chunk length")
+		}
+		if cnt >= 15 {
 			return 0, errors.New("http chunk length too large")
 		}
+		size <<= 4
+		size |= uint64(b)
 	}
-	return
+	if cnt == 0 {
+		return 0, errors.New("empty chunk length")
+	}
+	err = parseChunkExtensions(header[cnt:], func(name, value string) error {
+		if c.onExtension == nil {
+			return nil
+		}
+		return c.onExtension(int64(size), name, value)
+	})
+	return size, err
 }
 
 func (c *chunkedReader) Read(p []byte) (n int, err error) {
+	if c.sawEOF {
+		return 0, io.EOF
+	}
 	if c.currentChunkSize == -1 {
 		l, err := c.readChunkHeader()
 		if err != nil {
@@ -67,7 +128,7 @@ func (c *chunkedReader) Read(p []byte) (n int, err error) {
 		if int(c.currentChunkSize-c.currentCount) < len(p) {
 			p = p[:c.currentChunkSize-c.currentCount]
 		}
-		n, err = c.Reader.Read(p)
+		n, err = c.r.Read(p)
 		c.currentCount += int64(n)
 		if err != nil {
 			if err == io.EOF {
@@ -76,10 +137,18 @@ func (c *chunkedReader) Read(p []byte) (n int, err error) {
 			return n, err
 		}
 	}
+	if c.currentChunkSize == 0 {
+		// last-chunk is followed by the trailer section instead of a bare CRLF
+		if err := c.readTrailer(); err != nil {
+			return n, err
+		}
+		c.sawEOF = true
+		return n, io.EOF
+	}
 	if c.currentCount == c.currentChunkSize {
 		err = nil
-		dr, _ := c.Reader.ReadByte()
-		dn, err := c.Reader.ReadByte()
+		dr, _ := c.r.ReadByte()
+		dn, err := c.r.ReadByte()
 		if err != nil {
 			if err == io.EOF {
 				err = io.ErrUnexpectedEOF
@@ -89,9 +158,6 @@ func (c *chunkedReader) Read(p []byte) (n int, err error) {
 		if dr != '\r' || dn != '\n' {
 			return n, errors.New("malformed chunked encoding")
 		}
-		if c.currentChunkSize == 0 {
-			return n, io.EOF
-		}
 		c.currentCount = 0
 		c.currentChunkSize = -1
 	}
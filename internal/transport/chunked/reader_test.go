@@ -0,0 +1,58 @@
+package chunked
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestChunkedReaderExtensions(t *testing.T) {
+	type ext struct {
+		size        int64
+		name, value string
+	}
+	var got []ext
+	r := NewChunkedReader(strings.NewReader(
+		"5;a=b ; c = \"q\\\"x\";d\r\nhello\r\n" +
+			"6 \r\n world\r\n" +
+			"0;last\r\n\r\n"))
+	r.OnExtension(func(size int64, name, value string) error {
+		got = append(got, ext{size, name, value})
+		return nil
+	})
+	b, err := io.ReadAll(r)
+	if err != nil || string(b) != "hello world" {
+		t.Fatalf("unexpected body %q, err %v", b, err)
+	}
+	expected := []ext{{5, "a", "b"}, {5, "c", "q\"x"}, {5, "d", ""}, {0, "last", ""}}
+	if len(got) != len(expected) {
+		t.Fatalf("unexpected extensions %v", got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("unexpected extension %v, expected %v", got[i], expected[i])
+		}
+	}
+}
+
+func TestChunkedReaderMalformedExtensions(t *testing.T) {
+	for _, header := range []string{
+		"5;\r\n", "5;=b\r\n", "5;a=\r\n", "5;a=\"b\r\n", "5 x\r\n", ";a\r\n", "5g\r\n",
+		"5;a=" + strings.Repeat("b", maxChunkHeaderLength) + "\r\n",
+	} {
+		r := NewChunkedReader(strings.NewReader(header + "hello\r\n0\r\n\r\n"))
+		if _, err := io.ReadAll(r); err == nil {
+			t.Errorf("expected error for chunk header %q", header)
+		}
+	}
+}
+
+func TestChunkedReaderExtensionHandlerError(t *testing.T) {
+	abort := errors.New("abort")
+	r := NewChunkedReader(strings.NewReader("5;a=b\r\nhello\r\n0\r\n\r\n"))
+	r.OnExtension(func(int64, string, string) error { return abort })
+	if _, err := io.ReadAll(r); err != abort {
+		t.Errorf("expected handler error, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 )
 
 // NewChunkedWriter is taken from golang src/net/http/internal/chunked.go
@@ -41,11 +42,29 @@ func (cw *chunkedWriter) Write(data []byte) (n int, err error) {
 	return
 }
 
-func (cw *chunkedWriter) CloseWithTrailer(trailer http.Header) error {
-	n, err := io.WriteString(cw.Wire, "0\r\n\r\n")
-	if err == nil && n != 5 {
-		return io.ErrShortWrite
+// CloseWithTrailer writes the last chunk followed by the trailer section,
+// the trailer fields should be declared in the "Trailer" header beforehand.
+func (cw *chunkedWriter) CloseWithTrailer(trailer http.Header) (err error) {
+	if _, err = io.WriteString(cw.Wire, "0\r\n"); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(trailer))
+	for k := range trailer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range trailer[k] {
+			if _, err = fmt.Fprintf(cw.Wire, "%s: %s\r\n", k, v); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err = io.WriteString(cw.Wire, "\r\n"); err != nil {
+		return err
+	}
+	if f, ok := cw.Wire.(interface{ Flush() error }); ok {
+		err = f.Flush()
 	}
-	// TODO: write trailer
 	return err
 }
@@ -6,11 +6,15 @@ import (
 	"io"
 	"net"
 	nhttp "net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/frankli0324/go-http/internal/http"
 	"github.com/frankli0324/go-http/internal/transport/h2c"
 	errs "github.com/frankli0324/go-http/internal/transport/h2c/errors"
+	"github.com/frankli0324/go-http/utils/netpool"
 
 	"golang.org/x/net/http2"
 )
@@ -22,85 +26,215 @@ func (t H2C) RoundTrip(ctx context.Context, rw io.ReadWriteCloser, req *http.Pre
 	if !ok {
 		return errors.New("can only round trip to h2 stream")
 	}
-	if err := t.WriteRequest(ctx, s, req); err != nil {
+	gate := newContinueGate(req)
+	if err := t.WriteRequest(ctx, s, req, gate); err != nil {
 		return err
 	}
-	return t.ReadResponse(ctx, s, req, resp)
+	return t.ReadResponse(ctx, s, req, resp, gate)
 }
 
-func (h H2C) ReadResponse(ctx context.Context, s *h2c.Stream, req *http.PreparedRequest, resp *http.Response) error {
-	resp.Header = make(http.Header)
-	err := s.ReadHeaders(ctx, func(k, v string) error {
-		if len(k) > 0 && k[0] == ':' {
-			switch k {
-			case ":status":
-				code, err := strconv.Atoi(v)
-				if err != nil {
-					return err
+// newContinueGate returns the gate holding the request body until
+// 100 (Continue) is received, nil if the request doesn't expect it
+func newContinueGate(req *http.PreparedRequest) *http.ContinueGate {
+	if req.Body == nil || !req.ExpectsContinue() {
+		return nil
+	}
+	return http.NewContinueGate()
+}
+
+// ReadResponse reads the response of the stream, gate is the one passed to
+// [H2C.WriteRequest], which could be nil.
+func (h H2C) ReadResponse(ctx context.Context, s *h2c.Stream, req *http.PreparedRequest, resp *http.Response, gate *http.ContinueGate) error {
+	resp.Proto = "HTTP/2.0"
+	var err error
+	keepRaw := http.KeepRawHeader(ctx)
+	for n := 1; ; n++ {
+		resp.Header, resp.RawHeader = make(http.Header), nil
+		err = s.ReadHeaders(ctx, func(k, v string) error {
+			if keepRaw {
+				resp.RawHeader = append(resp.RawHeader, http.HeaderField{Name: k, Value: v})
+			}
+			if len(k) > 0 && k[0] == ':' {
+				switch k {
+				case ":status":
+					code, err := strconv.Atoi(v)
+					if err != nil {
+						return err
+					}
+					resp.StatusCode = code
+					resp.Status = v + " " + nhttp.StatusText(code)
+				default:
+					return errors.New("invalid response header")
 				}
-				resp.StatusCode = code
-				resp.Status = v + " " + nhttp.StatusText(code)
-			default:
-				return errors.New("invalid response header")
+			} else {
+				resp.Header.Add(k, v)
 			}
-		} else {
-			resp.Header.Add(k, v)
+			return nil
+		})
+		if err != nil || !http.IsInterim(resp.StatusCode) {
+			break
 		}
-		return nil
-	})
+		// interim response, the final response follows
+		if err = http.HandleInterim(ctx, n, resp.StatusCode, resp.Header); err != nil {
+			s.Reset(http2.ErrCodeCancel, false)
+			break
+		}
+		if gate != nil && resp.StatusCode == 100 {
+			gate.Continue(true)
+		}
+	}
+	if err == nil && resp.StatusCode == 101 {
+		// rfc9113 8.6: http2 does not support 101 (Switching Protocols)
+		s.Reset(http2.ErrCodeProtocol, false)
+		err = errors.New("unexpected 101 response over http2")
+	}
+	if gate != nil {
+		// a final response before 100 (Continue), the body is not sent
+		gate.Continue(false)
+	}
 	if err != nil {
 		return err
 	}
 
-	resp.Body = s.ResponseBodyStream(ctx)
+	resp.ContentLength = -1
+	if cl := resp.Header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			resp.ContentLength = n
+		}
+	}
+	if req.Method == "HEAD" || resp.StatusCode == 204 || resp.StatusCode == 304 {
+		resp.ContentLength = 0
+	}
+	resp.Body = s.ResponseBodyStream(ctx, func(k, v string) error {
+		if len(k) > 0 && k[0] == ':' {
+			// rfc9113 8.1: trailers must not include pseudo-header fields
+			return errors.New("invalid response trailer")
+		}
+		if resp.Trailer == nil {
+			resp.Trailer = make(http.Header)
+		}
+		resp.Trailer.Add(k, v)
+		return nil
+	})
 	return nil
 }
 
-func (h H2C) WriteRequest(ctx context.Context, s *h2c.Stream, req *http.PreparedRequest) error {
+// WriteRequest writes the request to the stream. If gate is not nil, it returns
+// once the headers are written, and the body is held until the gate opens.
+// rfc9110 10.1.1
+func (h H2C) WriteRequest(ctx context.Context, s *h2c.Stream, req *http.PreparedRequest, gate *http.ContinueGate) error {
+	if req.Raw != nil {
+		return errRawOverH2
+	}
 	stream, err := req.GetBody()
 	if err != nil {
 		return err
 	}
-	defer stream.Close()
+	// the body is owned by the goroutine writing it, which could outlive
+	// WriteRequest if the body is held by gate
+	var closeOnce sync.Once
+	closeBody := func() { closeOnce.Do(func() { stream.Close() }) }
 	hasBody := stream != http.NoBody
+	hasTrailer := len(req.Trailer) != 0
+	// the stream of a CONNECT request is left open for the tunneled data,
+	// see [H2Session.Tunnel]. rfc9113 8.5
+	endStream := !hasBody && !hasTrailer && req.Method != "CONNECT"
 
 	streamID, writtenHeaders := s.Connection.AssignStreamID(s)
-	done := make(chan struct{})
+	errCh := make(chan error, 1)
 	go func() {
-		err = s.WriteHeaders(ctx, func(f func(k, v string)) {
-			f(":method", req.Method)
-			f(":authority", req.HeaderHost)
-			if req.Method != "CONNECT" {
-				f(":scheme", req.U.Scheme)
-				f(":path", req.U.RequestURI())
-			}
-			for k, v := range req.Header {
-				for _, v := range v {
-					f(k, v)
+		err := s.WriteHeaders(ctx, func(f func(k, v string)) {
+			for _, k := range headerKeys(req, hasBody, hasTrailer) {
+				switch k {
+				case ":method":
+					f(k, req.Method)
+				case ":authority":
+					f(k, req.HeaderHost)
+				case ":scheme":
+					f(k, req.U.Scheme)
+				case ":path":
+					f(k, req.U.RequestURI())
+				case "content-length":
+					f(k, strconv.FormatInt(req.ContentLength, 10))
+				case "trailer":
+					f(k, trailerKeys(req.Trailer))
+				default:
+					lk := strings.ToLower(k)
+					switch lk {
+					// rfc9113 8.2.2: connection-specific header fields are not used in http2
+					case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+						continue
+					}
+					for _, v := range req.Header[k] {
+						f(lk, v)
+					}
 				}
 			}
-			if hasBody && req.ContentLength != -1 {
-				f("content-length", strconv.FormatInt(req.ContentLength, 10))
-			}
-		}, !hasBody /* && request has no trailers */)
+		}, endStream)
 		writtenHeaders() // can start write next request header
-		if err != nil {
+		if errors.Is(err, errs.ErrFramerWrite) {
+			// the header block is incomplete, which could not be processed
+			err = errs.ErrStreamNotSent.Stream(streamID).Wrap(err)
+		}
+		writeBody := func() (err error) {
+			if hasBody {
+				err = s.WriteRequestBody(ctx, stream, req.ContentLength, !hasTrailer)
+			}
+			if err == nil && hasTrailer {
+				// rfc9113 8.1: trailers are sent in a final HEADERS frame with END_STREAM
+				err = s.WriteHeaders(ctx, func(f func(k, v string)) {
+					for k, v := range req.Trailer {
+						for _, v := range v {
+							f(strings.ToLower(k), v)
+						}
+					}
+				}, true)
+			}
+			return err
+		}
+		if err != nil || gate == nil || !hasBody {
+			if err == nil {
+				err = writeBody()
+			}
+			closeBody()
+			errCh <- err
 			return
 		}
-		if hasBody {
-			err = s.WriteRequestBody(ctx, stream, req.ContentLength, true)
+		errCh <- nil // the response is read while the body is held
+		send, err := gate.Wait(ctx)
+		if err != nil {
+			err = errs.ErrStreamCancelled.Stream(streamID)
+		} else if send {
+			err = writeBody()
+		}
+		closeBody()
+		// a skipped body leaves the stream open, it's reset once
+		// the response body is closed
+		if err != nil {
+			resetStream(s, err)
 		}
-		// TODO: trailers support
-		close(done)
 	}()
 	select {
-	case <-done:
+	case err = <-errCh:
 	case <-ctx.Done():
+		// unblocks the goroutine if it's stuck reading the body
+		closeBody()
 		err = errs.ErrStreamCancelled.Stream(streamID)
 	}
 	if err == nil {
 		return nil
 	}
+	return resetStream(s, err)
+}
+
+var errRawOverH2 = errors.New("raw requests are only supported over http1")
+
+// resetStream resets the stream failed writing the request, it returns
+// the reason why the stream is closed.
+func resetStream(s *h2c.Stream, err error) error {
+	if serr := s.Err(); serr != nil {
+		return serr // already closed, e.g. refused by remote
+	}
 	if errors.Is(err, errs.ErrStreamCancelled) {
 		s.Reset(http2.ErrCodeCancel, false)
 	} else if errors.Is(err, errs.ErrFramerWrite) {
@@ -111,6 +245,145 @@ func (h H2C) WriteRequest(ctx context.Context, s *h2c.Stream, req *http.Prepared
 	return err
 }
 
+// headerKeys returns the field names of the HEADERS frame in order,
+// pseudo-header fields always come first. rfc9113 8.3
+func headerKeys(req *http.PreparedRequest, hasBody, hasTrailer bool) []string {
+	generated := []string{":method", ":authority"}
+	if req.Method != "CONNECT" {
+		generated = append(generated, ":scheme", ":path")
+	}
+	if hasBody && req.ContentLength != -1 {
+		generated = append(generated, "content-length")
+	}
+	if hasTrailer {
+		generated = append(generated, "trailer")
+	}
+	keys := req.HeaderKeys(generated...)
+	sort.SliceStable(keys, func(i, j int) bool {
+		return strings.HasPrefix(keys[i], ":") && !strings.HasPrefix(keys[j], ":")
+	})
+	return keys
+}
+
+// trailerKeys returns the value of the "Trailer" header declaring the trailers
+func trailerKeys(trailer http.Header) string {
+	keys := make([]string, 0, len(trailer))
+	for k := range trailer {
+		keys = append(keys, strings.ToLower(k))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// H2Conn implements [netpool.Conn] over a single *[h2c.Connection],
+// each session sends its request over a new stream.
+type H2Conn struct {
+	*h2c.Connection
+}
+
+func NewH2Conn(c net.Conn, cfg *h2c.Config) *H2Conn {
+	return &H2Conn{h2c.NewConnection(c, cfg)}
+}
+
+func (c *H2Conn) Setup(_ context.Context) error {
+	return c.Handshake()
+}
+
+func (c *H2Conn) Session(ctx context.Context, s netpool.Session) (netpool.Session, error) {
+	if err := c.Valid(); err != nil {
+		if s != nil {
+			s.Release(true)
+		}
+		return nil, err
+	}
+	return &H2Session{Sess: s, c: c}, nil
+}
+
+// H2Session is a single request/response exchange over an h2 stream,
+// the session itself is used as the response body, like [http1.Session].
+type H2Session struct {
+	Sess netpool.Session // could be nil if connection is not a session
+	c    *H2Conn
+
+	stream *h2c.Stream
+	gate   *http.ContinueGate // could be nil
+	body   io.ReadCloser
+}
+
+func (s *H2Session) Do(ctx context.Context, req *http.PreparedRequest, resp *http.Response) error {
+	stream, err := s.c.Stream()
+	if err != nil {
+		s.Release(true)
+		return http.NotProcessed(err) // the connection is gone before any stream opened
+	}
+	s.stream, s.gate = stream, newContinueGate(req)
+	if err := (H2C{}).WriteRequest(ctx, stream, req, s.gate); err != nil {
+		s.Release(s.c.Valid() != nil)
+		return notProcessed(err)
+	}
+	return s.readResponse(ctx, req, resp)
+}
+
+func (s *H2Session) readResponse(ctx context.Context, req *http.PreparedRequest, resp *http.Response) error {
+	if err := (H2C{}).ReadResponse(ctx, s.stream, req, resp, s.gate); err != nil {
+		s.Release(s.c.Valid() != nil)
+		return notProcessed(err)
+	}
+	s.body, resp.Body = resp.Body, s
+	return nil
+}
+
+// Tunnel returns the connection to the target of a CONNECT request over
+// the stream, after [H2Session.Do] succeeds with a 2xx response. Closing
+// the connection resets the stream and releases the session. rfc9113 8.5
+func (s *H2Session) Tunnel() net.Conn {
+	return h2Tunnel{s.stream, s}
+}
+
+// h2Tunnel reads and writes the DATA frames of the stream, while the
+// deadlines only apply to the stream instead of the whole connection.
+type h2Tunnel struct {
+	*h2c.Stream
+	s *H2Session
+}
+
+func (t h2Tunnel) Close() error {
+	return t.s.Close()
+}
+
+// implements netpool.Session
+func (s *H2Session) Release(close bool) (reused bool, err error) {
+	if s.Sess == nil {
+		return
+	}
+	reused, err = s.Sess.Release(close)
+	s.Sess = nil
+	return
+}
+
+func (s *H2Session) Read(buf []byte) (int, error) {
+	return s.body.Read(buf)
+}
+
+// implements ReadCloser
+func (s *H2Session) Close() error {
+	if s.stream.Valid() { // response body not fully consumed
+		s.stream.Reset(http2.ErrCodeCancel, false)
+	}
+	err := s.body.Close()
+	s.Release(s.c.Valid() != nil)
+	return err
+}
+
+// notProcessed marks streams refused by the peer, or never sent to it, as
+// not processed, so that they could be retried on a new connection.
+func notProcessed(err error) error {
+	if errors.Is(err, errs.ErrStreamRefused) || errors.Is(err, errs.ErrStreamNotSent) {
+		return http.NotProcessed(err)
+	}
+	return err
+}
+
 func getRawConn(c interface{}) net.Conn {
 	if conn, ok := c.(interface{ Raw() net.Conn }); ok {
 		return conn.Raw()
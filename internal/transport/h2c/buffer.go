@@ -0,0 +1,122 @@
+package h2c
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// recvBuffer holds DATA received on a stream until it's consumed by the
+// application. Writes never block the frame read loop, the buffered size
+// is bounded by the inbound flow-control window of the stream, which is
+// checked before writing.
+type recvBuffer struct {
+	mu   sync.Mutex
+	cond sync.Cond
+
+	chunks [][]byte
+	n      int   // total buffered bytes
+	err    error // returned after buffered data is drained
+
+	readerClosed bool
+
+	deadline time.Time   // reads waiting for data fail after deadline
+	timer    *time.Timer // wakes up the readers once deadline exceeds
+}
+
+func (b *recvBuffer) init() *recvBuffer {
+	b.cond.L = &b.mu
+	return b
+}
+
+// Write copies p into the buffer, it returns false if the data is dropped
+// since the reader is already closed.
+func (b *recvBuffer) Write(p []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.readerClosed || b.err != nil {
+		return false
+	}
+	if len(p) > 0 {
+		b.chunks = append(b.chunks, append([]byte(nil), p...))
+		b.n += len(p)
+		b.cond.Signal()
+	}
+	return true
+}
+
+// CloseWithError makes reads return err after the buffered data is drained,
+// only the first call takes effect.
+func (b *recvBuffer) CloseWithError(err error) {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+func (b *recvBuffer) Read(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.n == 0 && b.err == nil && !b.readerClosed {
+		if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		b.cond.Wait()
+	}
+	if b.readerClosed {
+		return 0, io.ErrClosedPipe
+	}
+	if b.n == 0 {
+		return 0, b.err
+	}
+	if b.err != nil && b.err != io.EOF {
+		return 0, b.err // stream reset, unread data is useless
+	}
+	for len(p) > 0 && len(b.chunks) > 0 {
+		c := copy(p, b.chunks[0])
+		p, n = p[c:], n+c
+		if c == len(b.chunks[0]) {
+			b.chunks[0] = nil
+			b.chunks = b.chunks[1:]
+		} else {
+			b.chunks[0] = b.chunks[0][c:]
+		}
+	}
+	b.n -= n
+	return n, nil
+}
+
+// CloseRead drops the buffered data and further writes,
+// it returns the number of bytes dropped.
+func (b *recvBuffer) CloseRead() int {
+	b.mu.Lock()
+	dropped := b.n
+	b.chunks, b.n = nil, 0
+	b.readerClosed = true
+	b.mu.Unlock()
+	b.cond.Broadcast()
+	return dropped
+}
+
+// SetDeadline makes blocked reads return [os.ErrDeadlineExceeded] after t,
+// zero t means no deadline.
+func (b *recvBuffer) SetDeadline(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deadline = t
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if d := time.Until(t); !t.IsZero() && d > 0 {
+		b.timer = time.AfterFunc(d, func() {
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		})
+	}
+	b.cond.Broadcast()
+}
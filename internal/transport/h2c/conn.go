@@ -2,68 +2,92 @@ package h2c
 
 import (
 	"errors"
-	"io"
+	"math"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/frankli0324/go-http/internal/transport/h2c/controller"
+	errs "github.com/frankli0324/go-http/internal/transport/h2c/errors"
 	"golang.org/x/net/http2"
 )
 
-func NewConnection(c net.Conn) *Connection {
+// Config holds the options for http2 connections, nil values are
+// replaced by their defaults.
+type Config struct {
+	// GracefulTimeout bounds the time waiting for in-flight streams to finish
+	// after GOAWAY is sent while closing the connection. default 30 seconds
+	GracefulTimeout time.Duration
+
+	// ReadIdleTimeout is the period of read inactivity after which a PING frame
+	// is sent to check the health of the connection. zero disables keepalive
+	ReadIdleTimeout time.Duration
+	// PingTimeout is the time waiting for the PING ack before the connection
+	// is considered dead and closed. default 10 seconds
+	PingTimeout time.Duration
+}
+
+func (c *Config) Clone() *Config {
+	if c == nil {
+		return nil
+	}
+	cfg := *c
+	return &cfg
+}
+
+func (c *Config) gracefulTimeout() time.Duration {
+	if c == nil || c.GracefulTimeout == 0 {
+		return 30 * time.Second
+	}
+	return c.GracefulTimeout
+}
+
+const (
+	initialWindowSize = 65535
+	// connInflowWindow is larger than the stream window, so that a stream
+	// whose body is not read doesn't block the others. taken from x/net/http2
+	connInflowWindow = 1 << 30
+)
+
+func NewConnection(c net.Conn, cfg *Config) *Connection {
 	ctrl := controller.NewController(c)
+	if cfg != nil {
+		ctrl.ConfigureKeepAlive(cfg.ReadIdleTimeout, cfg.PingTimeout)
+	}
 	conn := &Connection{
-		Conn:          c,
-		controller:    ctrl,
-		lastStreamID:  -1, /* step up by 2 */
-		activeStreams: make(map[uint32]*Stream),
-		inflow:        &inflow{}, // TODO: support configure custom flow ctrl
-		outflow:       &outflow{},
+		Conn:         c,
+		cfg:          cfg,
+		controller:   ctrl,
+		lastStreamID: -1, /* step up by 2 */
+
+		remoteLastStreamID: -1,
+		activeStreams:      make(map[uint32]*Stream),
+		inflow:             &inflow{}, // TODO: support configure custom flow ctrl
+		outflow:            &outflow{},
 	}
 	conn.condOutflow.L = &sync.Mutex{}
 
 	conn.condActive = sync.NewCond(&conn.muActive)
 	ctrl.OnHeader(func(frame *http2.MetaHeadersFrame) {
 		conn.withStream(frame.StreamID, func(active *Stream) error {
-			active.chanHeaders <- frame
-			if frame.StreamEnded() {
-				active.respWriter.Close() // no body
-				active.Close()
-			}
-			return nil
+			return active.receiveHeaders(frame)
 		})
 	})
 	ctrl.OnData(func(frame *http2.DataFrame) {
-		if !conn.inflow.CheckAndPay(frame.Length) {
+		conn.muInflow.Lock()
+		ok := conn.inflow.CheckAndPay(frame.Length)
+		conn.muInflow.Unlock()
+		if !ok {
 			conn.GoAway(http2.ErrCodeFlowControl)
+			return
 		}
-		data := frame.Data()
-		pad := frame.Length - uint32(len(data))
-		if pad > 0 {
-			conn.inflow.Refund(pad)
+		if !conn.withStream(frame.StreamID, func(active *Stream) error {
+			return active.receiveData(frame)
+		}) {
+			// data on closed streams still counts against the connection window
+			conn.refundInflow(nil, frame.Length)
 		}
-		conn.withStream(frame.StreamID, func(active *Stream) error {
-			read := 0
-			for read != len(data) {
-				if n, err := active.respWriter.Write(data); err != nil {
-					if inc := conn.inflow.Refund(uint32(len(data) - n)); inc != 0 {
-						ctrl.WriteWindowUpdate(0, inc)
-					}
-					return err
-				} else {
-					if inc := conn.inflow.Refund(uint32(n)); inc != 0 {
-						ctrl.WriteWindowUpdate(0, inc)
-					}
-					read += n
-				}
-			}
-			if frame.StreamEnded() {
-				active.respWriter.Close()
-				active.Close()
-			}
-			return nil
-		})
 	})
 	ctrl.OnStreamReset(func(frame *http2.RSTStreamFrame) {
 		if frame.ErrCode != http2.ErrCodeNo {
@@ -73,14 +97,43 @@ func NewConnection(c net.Conn) *Connection {
 		}
 	})
 	ctrl.OnRemoteGoAway(func(u uint32, err http2.ErrCode) {
-		conn.muActive.Lock()
+		atomic.StoreInt64(&conn.remoteLastStreamID, int64(u))
+		conn.muActive.RLock()
+		refused := make([]*Stream, 0, len(conn.activeStreams))
 		for id, stream := range conn.activeStreams {
-			if id > u {
-				stream.Reset(err, true)
-				delete(conn.activeStreams, id)
+			if !conn.ShouldProcess(id) {
+				refused = append(refused, stream)
+			}
+		}
+		conn.muActive.RUnlock()
+		// rfc9113 6.8: streams initiated by the sender with identifiers higher than
+		// the last stream ID were not and will not be processed by the peer,
+		// so they could be safely retried on a new connection
+		for _, stream := range refused {
+			stream.CloseWithError(errs.ErrStreamRefused.Stream(stream.streamID).Wrap(conn.Valid()))
+		}
+	})
+	ctrl.OnClosed(func(reason error) {
+		conn.muActive.RLock()
+		streams := make([]*Stream, 0, len(conn.activeStreams))
+		for _, stream := range conn.activeStreams {
+			streams = append(streams, stream)
+		}
+		conn.muActive.RUnlock()
+		lost := errs.ErrConnectionLost
+		if errors.Is(reason, controller.ErrPingTimeout) {
+			lost = errs.ErrPingTimeout
+		}
+		// headers are written in the order of stream IDs, the streams after
+		// the last one sent never reached the peer
+		lastSent := atomic.LoadUint32(&conn.lastSentStreamID)
+		for _, stream := range streams {
+			var err error = lost.Stream(stream.streamID).Wrap(reason)
+			if stream.streamID > lastSent {
+				err = errs.ErrStreamNotSent.Stream(stream.streamID).Wrap(err)
 			}
+			stream.CloseWithError(err)
 		}
-		conn.muActive.Unlock()
 	})
 	ctrl.OnSettings(func(sf *http2.SettingsFrame) {
 		if sf.IsAck() {
@@ -115,15 +168,16 @@ func NewConnection(c net.Conn) *Connection {
 		_ = ctrl.WriteSettingsAck()
 	})
 
-	swnd, done := ctrl.UseSelfSetting(http2.SettingInitialWindowSize)
-	conn.inflow.ResetInitialBalance(swnd)
-	done()
+	conn.inflow.ResetInitialBalance(connInflowWindow)
 	// rfc7540 S 6.9.2.: A SETTINGS frame cannot alter the connection flow-control window.
 	// so conn.outflow.ResetInitialBalance is called only at initialization
 	iw, done := ctrl.UsePeerSetting(http2.SettingInitialWindowSize)
 	conn.outflow.Refund(iw)
 	done()
 	ctrl.OnAfterHandshake(func() {
+		// rfc9113 6.9.2: the initial connection window is always 65535,
+		// only WINDOW_UPDATE frames could enlarge it
+		ctrl.WriteWindowUpdate(0, connInflowWindow-initialWindowSize)
 	})
 	ctrl.OnWindowUpdate(func(frame *http2.WindowUpdateFrame) {
 		conn.condOutflow.L.Lock()
@@ -147,15 +201,22 @@ func NewConnection(c net.Conn) *Connection {
 
 type Connection struct {
 	net.Conn
+	cfg *Config
 
 	inflow  InflowCtrl
 	outflow OutflowCtrl
 
+	muInflow sync.Mutex // guards inflow of the connection and its streams
+
 	condOutflow sync.Cond
 
 	controller   *controller.Controller
 	lastStreamID int32
 
+	lastSentStreamID uint32 // the last stream with headers fully written, accessed atomically
+
+	remoteLastStreamID int64 // last stream ID in GOAWAY sent by peer, -1 if not received
+
 	activeStreams map[uint32]*Stream
 	muActive      sync.RWMutex
 	condActive    *sync.Cond
@@ -167,30 +228,93 @@ func (c *Connection) Handshake() error {
 	return c.controller.Handshake()
 }
 
-// withStream must be executed only by frame read loop synchronously
-func (c *Connection) withStream(streamID uint32, f func(*Stream) error) {
+// Upgrade completes the HTTP/1.1 Upgrade to h2c after 101 response is received,
+// [Connection.UpgradedStream] must be called before Upgrade.
+func (c *Connection) Upgrade() error {
+	return c.controller.Upgrade()
+}
+
+// UpgradeSettings returns the value of HTTP2-Settings request header field
+func (c *Connection) UpgradeSettings() string {
+	return c.controller.UpgradeSettings()
+}
+
+// UpgradedStream returns stream 1, which is implicitly opened by the HTTP/1.1
+// request that initiated the Upgrade, and is "half-closed (local)" from the
+// client's perspective. rfc7540 3.2
+func (c *Connection) UpgradedStream() (*Stream, error) {
+	s, err := c.Stream()
+	if err != nil {
+		return nil, err
+	}
+	sid, writtenHeaders := c.AssignStreamID(s)
+	writtenHeaders()
+	if sid != 1 {
+		s.Reset(http2.ErrCodeInternal, false)
+		return nil, errors.New("upgraded stream must be the first stream on the connection")
+	}
+	return s, nil
+}
+
+// Valid returns error if no more streams could be opened on the connection
+func (c *Connection) Valid() error {
+	return c.controller.Valid()
+}
+
+// withStream must be executed only by frame read loop synchronously,
+// it returns false if the stream is not active.
+func (c *Connection) withStream(streamID uint32, f func(*Stream) error) bool {
 	c.muActive.RLock()
 	active := c.activeStreams[streamID]
 	c.muActive.RUnlock()
 
 	if !active.Valid() {
 		c.controller.WriteRSTStream(streamID, http2.ErrCodeStreamClosed)
+		return false
 	} else if err := f(active); err != nil {
 		active.Reset(http2.ErrCodeInternal, false)
 	}
+	return true
+}
+
+// headersSent records that the headers of the stream are fully written
+func (c *Connection) headersSent(streamID uint32) {
+	for {
+		last := atomic.LoadUint32(&c.lastSentStreamID)
+		if streamID <= last || atomic.CompareAndSwapUint32(&c.lastSentStreamID, last, streamID) {
+			return
+		}
+	}
+}
+
+// refundInflow returns the window of consumed data to the peer, the stream
+// window is only updated if s is not nil and still open.
+func (c *Connection) refundInflow(s *Stream, sz uint32) {
+	c.muInflow.Lock()
+	connInc := c.inflow.Refund(sz)
+	var streamInc uint32
+	if s.Valid() {
+		streamInc = s.inflow.Refund(sz)
+	}
+	c.muInflow.Unlock()
+	if connInc != 0 {
+		c.controller.WriteWindowUpdate(0, connInc)
+	}
+	if streamInc != 0 {
+		c.controller.WriteWindowUpdate(s.streamID, streamInc)
+	}
 }
 
 func (c *Connection) Stream() (*Stream, error) {
 	if err := c.controller.Valid(); err != nil {
 		return nil, err
 	}
-	r, w := io.Pipe()
 	s := &Stream{
 		Connection:  c,
-		chanHeaders: make(chan *http2.MetaHeadersFrame),
+		chanHeaders: make(chan *http2.MetaHeadersFrame, maxQueuedHeaders),
 		done:        make(chan struct{}),
 
-		respReader: r, respWriter: w,
+		recv: (&recvBuffer{}).init(),
 
 		inflow: &inflow{}, outflow: &outflow{},
 	}
@@ -201,7 +325,7 @@ func (c *Connection) ReleaseStreamID(s *Stream) {
 	c.muActive.Lock()
 	delete(c.activeStreams, s.streamID)
 	c.muActive.Unlock()
-	c.condActive.Signal()
+	c.condActive.Broadcast()
 }
 
 func (c *Connection) AssignStreamID(s *Stream) (sid uint32, writtenHeaders func()) {
@@ -222,29 +346,69 @@ func (c *Connection) AssignStreamID(s *Stream) (sid uint32, writtenHeaders func(
 	s.outflow.Refund(pwnd)
 	done()
 	swnd, done := s.controller.UseSelfSetting(http2.SettingInitialWindowSize)
+	c.muInflow.Lock()
 	s.inflow.ResetInitialBalance(swnd) // should be always valid
+	c.muInflow.Unlock()
 	done()
 	return s.streamID, c.muNewStream.Unlock
 }
 
+// Capacity reports the number of streams the connection is able to serve concurrently,
+// following the peer's SETTINGS_MAX_CONCURRENT_STREAMS. It returns zero if the
+// connection is no longer valid, e.g. GOAWAY seen, or running out of stream IDs.
+func (c *Connection) Capacity() uint32 {
+	if c.Valid() != nil {
+		return 0
+	}
+	maxConcStreams, done := c.controller.UsePeerSetting(http2.SettingMaxConcurrentStreams)
+	done()
+	if remaining := uint32(math.MaxInt32-atomic.LoadInt32(&c.lastStreamID)) / 2; remaining < maxConcStreams {
+		return remaining
+	}
+	return maxConcStreams
+}
+
+// Close gracefully shuts down the connection, GOAWAY is sent immediately,
+// and the underlying connection is closed after all active streams finish,
+// or [Config.GracefulTimeout] exceeds. It returns before the connection is closed.
+// The connection is closed the same way if GOAWAY is already sent or received.
 func (c *Connection) Close() error {
-	// never close unless goaway
-	return nil
+	err := c.controller.WaitAndClose(0, c.idle(), c.cfg.gracefulTimeout())
+	if err == controller.ErrMultipleGoAway {
+		// already shutting down
+		return nil
+	}
+	return err
 }
 
+// idle returns a channel which is closed once there's no active streams.
+func (c *Connection) idle() <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		c.muActive.Lock()
+		for len(c.activeStreams) != 0 {
+			c.condActive.Wait()
+		}
+		c.muActive.Unlock()
+		close(ch)
+	}()
+	return ch
+}
+
+// the last stream ID in GOAWAY frames sent by us is the last stream initiated
+// by the server, which is always 0 since server push is disabled.
 func (c *Connection) GoAway(code http2.ErrCode) error {
-	return c.controller.GoAway(uint32(atomic.LoadInt32(&c.lastStreamID)), code)
+	return c.controller.GoAway(0, code)
 }
 
 func (c *Connection) GoAwayDebug(code http2.ErrCode, debug []byte) error {
-	return c.controller.GoAwayDebug(uint32(atomic.LoadInt32(&c.lastStreamID)), code, debug)
+	return c.controller.GoAwayDebug(0, code, debug)
 }
 
-// ShouldProcess checks if stream should be retried on a new connection
-// if some error occurred
+// ShouldProcess reports whether the stream might have been processed by the
+// peer. A stream that should not be processed could be retried on a new
+// connection safely if some error occurred.
 func (c *Connection) ShouldProcess(streamID uint32) bool {
-	if c.controller.Valid() == nil {
-		return true
-	}
-	return uint32(atomic.LoadInt32(&c.lastStreamID)) >= streamID
+	last := atomic.LoadInt64(&c.remoteLastStreamID)
+	return last == -1 || int64(streamID) <= last
 }
@@ -1,19 +1,22 @@
 package controller
 
 import (
+	"encoding/base64"
 	"errors"
 	"io"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/http2"
 )
 
 func NewController(c net.Conn) *Controller {
 	conn := &Controller{
-		Conn: c,
-		done: make(chan struct{}),
+		Conn:   c,
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
 	}
 	conn.settingsMixin = newSettingsMixin()
 	conn.hpackMixin.init(conn)
@@ -28,14 +31,17 @@ func NewController(c net.Conn) *Controller {
 				code:   frame.ErrCode,
 				debug:  make([]byte, len(debug)),
 				remote: true,
+				last:   frame.LastStreamID,
 			}
 			copy(reason.debug, debug)
 			conn.doneReason = reason
 			close(conn.done)
-			if conn.onRemoteGoAway != nil {
-				conn.onRemoteGoAway(frame.LastStreamID, frame.ErrCode)
-			}
 		})
+		// the last stream ID applies even if we have sent GOAWAY first,
+		// or the peer sends GOAWAY again with a lower one. rfc9113 6.8
+		if conn.onRemoteGoAway != nil {
+			conn.onRemoteGoAway(frame.LastStreamID, frame.ErrCode)
+		}
 	}
 	return conn
 }
@@ -56,6 +62,13 @@ type Controller struct {
 	doneOnce   sync.Once
 	doneReason error
 
+	shutdownOnce sync.Once // guards the graceful close in [Controller.WaitAndClose]
+
+	// closed is closed once the frame read loop exits, which happens after
+	// the underlying connection is closed, possibly long after done.
+	closed      chan struct{}
+	closeReason atomic.Value // error, why the connection is closed abruptly
+
 	framerMixin
 	hpackMixin
 	pingMixin
@@ -66,6 +79,7 @@ type Controller struct {
 
 	onAfterHandshake []func()
 	onRemoteGoAway   func(lastStreamID uint32, errCode http2.ErrCode)
+	onClosed         func(reason error)
 }
 
 // GoAway actively sends GOAWAY to remote peer.
@@ -81,7 +95,7 @@ func (c *Controller) GoAway(lastStreamID uint32, code http2.ErrCode) (err error)
 func (c *Controller) GoAwayDebug(lastStreamID uint32, code http2.ErrCode, debug []byte) (err error) {
 	err = ErrMultipleGoAway
 	c.doneOnce.Do(func() {
-		c.doneReason = &ReasonGoAway{code: code, debug: debug, remote: false}
+		c.doneReason = &ReasonGoAway{code: code, debug: debug, remote: false, last: lastStreamID}
 		close(c.done)
 		err = c.WriteGoAway(lastStreamID, code, debug)
 		atomic.StoreUint32(&c.closing, 1)
@@ -134,31 +148,103 @@ func (c *Controller) Handshake() error {
 	}
 
 	// successful handshake
+	atomic.StoreInt64(&c.lastRead, time.Now().UnixNano())
 	go c.consumer()
+	if c.readIdleTimeout != 0 {
+		go c.keepAlive(c)
+	}
 	return nil
 }
 
-// Upgrade performs HTTP1 Upgrade to h2c on the underlying [net.Conn]
-// GET / HTTP/1.1
-// Host: example.com
-// Connection: Upgrade, HTTP2-Settings
-// Upgrade: h2c
-// HTTP2-Settings: <base64url encoding of HTTP/2 SETTINGS payload>
-func (c *Controller) Upgrade(host string) error {
-	panic("unimplemented")
+// closeWithReason closes the connection abruptly without sending GOAWAY,
+// e.g. the connection is found to be dead. reason is reported by [Controller.OnClosed]
+// even if the connection is already shutting down.
+func (c *Controller) closeWithReason(reason error) {
+	c.doneOnce.Do(func() {
+		c.doneReason = reason
+		close(c.done)
+	})
+	c.closeReason.Store(reason)
+	atomic.StoreUint32(&c.closing, 1)
+	c.Conn.Close()
+}
+
+// UpgradeSettings returns the value of HTTP2-Settings header field to be sent
+// with the HTTP/1.1 request that initiates the Upgrade to h2c.
+//
+//	GET / HTTP/1.1
+//	Host: example.com
+//	Connection: Upgrade, HTTP2-Settings
+//	Upgrade: h2c
+//	HTTP2-Settings: <base64url encoding of HTTP/2 SETTINGS payload>
+func (c *Controller) UpgradeSettings() string {
+	payload := make([]byte, 0, 6*8)
+	for _, s := range c.selfSettingsList() {
+		payload = append(payload, byte(s.ID>>8), byte(s.ID), byte(s.Val>>24), byte(s.Val>>16), byte(s.Val>>8), byte(s.Val))
+	}
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// Upgrade completes HTTP1 Upgrade to h2c on the underlying [net.Conn] after
+// a 101 (Switching Protocols) response is received, rfc7540 3.2:
+//
+// The first HTTP/2 frame sent by the server MUST be a server connection preface
+// (Section 3.5) consisting of a SETTINGS frame (Section 6.5). Upon receiving
+// the 101 response, the client MUST send a connection preface (Section 3.5),
+// which includes a SETTINGS frame.
+func (c *Controller) Upgrade() error {
+	return c.Handshake()
 }
 
-// Close on *Framer should try to gracefully shutdown the underlying connection asynchronously
-func (c *Controller) WaitAndClose() error {
-	panic("unimplemented")
+// WaitAndClose gracefully shuts down the connection asynchronously. GOAWAY
+// with lastStreamID is sent immediately so that no more new streams would be
+// created, and the underlying connection is closed after idle is closed, or
+// the timeout exceeds, whichever happens first. If the connection is already
+// done, e.g. GOAWAY received, GOAWAY is not sent and [ErrMultipleGoAway] is
+// returned, while the underlying connection is still closed the same way.
+func (c *Controller) WaitAndClose(lastStreamID uint32, idle <-chan struct{}, timeout time.Duration) (err error) {
+	err = ErrMultipleGoAway
+	c.doneOnce.Do(func() {
+		c.doneReason = &ReasonGoAway{code: http2.ErrCodeNo, remote: false, last: lastStreamID}
+		close(c.done)
+		err = c.WriteGoAway(lastStreamID, http2.ErrCodeNo, nil)
+	})
+	c.shutdownOnce.Do(func() {
+		go func() {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			select {
+			case <-idle:
+			case <-timer.C:
+			}
+			atomic.StoreUint32(&c.closing, 1)
+			c.Conn.Close()
+		}()
+	})
+	return
 }
 
 func (c *Controller) consumer() error {
+	defer func() {
+		close(c.closed)
+		if c.onClosed != nil {
+			reason, _ := c.closeReason.Load().(error)
+			if reason == nil {
+				reason = c.Valid()
+			}
+			c.onClosed(reason)
+		}
+	}()
 	for atomic.LoadUint32(&c.closing) == 0 {
 		f, err := c.ReadFrame()
 		if err != nil {
+			c.doneOnce.Do(func() {
+				c.doneReason = err
+				close(c.done)
+			})
 			return err
 		}
+		atomic.StoreInt64(&c.lastRead, time.Now().UnixNano())
 		// keep things sending
 		// TODO: implement flow control
 
@@ -210,3 +296,10 @@ func (c *Controller) OnAfterHandshake(cb func()) {
 func (c *Controller) OnRemoteGoAway(cb func(lastStreamID uint32, errCode http2.ErrCode)) {
 	c.onRemoteGoAway = cb
 }
+
+// OnClosed registers the callback invoked after the frame read loop exits,
+// reason is the error returned by [Controller.Valid] at that time, or the
+// keepalive failure if the connection is closed for it.
+func (c *Controller) OnClosed(cb func(reason error)) {
+	c.onClosed = cb
+}
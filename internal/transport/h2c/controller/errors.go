@@ -10,6 +10,7 @@ import (
 var (
 	ErrMultipleGoAway = errors.New("connection already seen GOAWAY")
 	ErrReasonNil      = errors.New("connection closed without reason, this is unexpected")
+	ErrPingTimeout    = errors.New("keepalive ping failed, connection considered dead")
 )
 
 type ReasonGoAway struct {
@@ -2,8 +2,10 @@ package controller
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -14,17 +16,25 @@ type pingMixin struct {
 	pingFut    map[uint64]chan interface{}
 	muPing     sync.RWMutex
 	_writePing func(ack bool, data [8]byte) error
+
+	lastRead int64 // unix nano, updated by the frame read loop
+
+	readIdleTimeout, pingTimeout time.Duration
 }
 
 func (p *pingMixin) init(c *Controller) {
 	c._writePing = c.WritePing
 	c.pingFut = map[uint64]chan interface{}{}
+	c.pingTimeout = 10 * time.Second
 	c.on[http2.FramePing] = func(frame http2.Frame) {
 		pingFrame := frame.(*http2.PingFrame)
 		if pingFrame.IsAck() {
 			c.muPing.RLock()
 			if v, ok := c.pingFut[*(*uint64)(unsafe.Pointer(&pingFrame.Data[0]))]; ok {
-				v <- nil
+				select {
+				case v <- nil:
+				default: // acked twice
+				}
 				// make sure this is inside critical zone
 				// or write after close may happen
 			}
@@ -41,13 +51,26 @@ func (p *pingMixin) init(c *Controller) {
 	}
 }
 
-// Ping could fail due to unstable connection when the server doesn't acknoledge it in 10 seconds,
-// try not make connection state change decisions based on the Ping results.
+// ConfigureKeepAlive makes the connection send a PING frame after no frame is
+// received for readIdleTimeout, and the connection is considered dead and closed
+// if the PING is not acknowledged in pingTimeout. Zero readIdleTimeout disables
+// keepalive, and zero pingTimeout keeps the default 10 seconds.
+// It shall be called before handshake.
+func (p *pingMixin) ConfigureKeepAlive(readIdleTimeout, pingTimeout time.Duration) {
+	p.readIdleTimeout = readIdleTimeout
+	if pingTimeout != 0 {
+		p.pingTimeout = pingTimeout
+	}
+}
+
+// Ping could fail due to unstable connection when the server doesn't acknoledge it in the
+// configured ping timeout (10 seconds by default), try not make connection state change
+// decisions based on the Ping results other than keepalive.
 // This is mostly used for debugging and keeping connection alive.
 // Ping shouldn't be called rapidly or a large number of channels would be created.
 func (p *pingMixin) Ping() error {
 	data := rand.Uint64()
-	bdata, res := *(*[8]byte)(unsafe.Pointer(&data)), make(chan interface{})
+	bdata, res := *(*[8]byte)(unsafe.Pointer(&data)), make(chan interface{}, 1)
 	p.muPing.Lock()
 	p.pingFut[data] = res
 	p.muPing.Unlock()
@@ -55,16 +78,45 @@ func (p *pingMixin) Ping() error {
 		p.muPing.Lock()
 		delete(p.pingFut, data)
 		p.muPing.Unlock()
-		close(res)
 	}()
 
 	if err := p._writePing(false, bdata); err != nil {
 		return err
 	}
+	timer := time.NewTimer(p.pingTimeout)
+	defer timer.Stop()
 	select {
-	case <-time.After(10 * time.Second):
-		return errors.New("ping timeout 10 seconds")
+	case <-timer.C:
+		return fmt.Errorf("%w, no ack in %s", ErrPingTimeout, p.pingTimeout)
 	case <-res:
 		return nil
 	}
 }
+
+// keepAlive pings the peer after the connection stays silent for
+// readIdleTimeout, and closes the connection if the ping fails. It keeps
+// running while the connection drains after GOAWAY, until it's closed.
+func (p *pingMixin) keepAlive(c *Controller) {
+	timer := time.NewTimer(p.readIdleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-timer.C:
+		}
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&p.lastRead)))
+		if idle < p.readIdleTimeout {
+			timer.Reset(p.readIdleTimeout - idle)
+			continue
+		}
+		if err := p.Ping(); err != nil {
+			if !errors.Is(err, ErrPingTimeout) {
+				err = fmt.Errorf("%w, %s", ErrPingTimeout, err.Error())
+			}
+			c.closeWithReason(err)
+			return
+		}
+		timer.Reset(p.readIdleTimeout)
+	}
+}
@@ -43,6 +43,10 @@ func (s *settingsMixin) ConfigureReadSetting(id http2.SettingID, val uint32) err
 }
 
 func (s *settingsMixin) advertiseSettings(c *Controller) error {
+	return c.WriteSettings(s.selfSettingsList()...)
+}
+
+func (s *settingsMixin) selfSettingsList() []http2.Setting {
 	settings := make([]http2.Setting, 0, 8)
 	for id := 1; id <= 6; id++ {
 		setting := http2.Setting{
@@ -53,7 +57,7 @@ func (s *settingsMixin) advertiseSettings(c *Controller) error {
 			settings = append(settings, setting)
 		}
 	}
-	return c.WriteSettings(settings...)
+	return settings
 }
 
 func newSelfSettings() settings {
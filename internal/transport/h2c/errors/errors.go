@@ -48,6 +48,12 @@ var (
 	ErrReqBodyTooLong  = StreamError{"internal: request body larger than specified content length", 0, nil}
 	ErrReqBodyRead     = StreamError{"internal: request body read error", 0, nil}
 	ErrFramerWrite     = StreamError{"internal: framer write error", 0, nil}
+	ErrConnectionLost  = StreamError{"connection lost", 0, nil}
+	ErrPingTimeout     = StreamError{"connection lost, keepalive ping timeout", 0, nil}
+	ErrStreamRefused   = StreamError{"stream refused and not processed by remote", 0, nil}
+	// ErrStreamNotSent wraps the errors of streams whose request headers were
+	// never fully written, so that they're known not processed by remote
+	ErrStreamNotSent = StreamError{"request headers not sent", 0, nil}
 )
 
 type h2Code http2.ErrCode
@@ -2,10 +2,11 @@ package h2c
 
 import (
 	"context"
-	"errors"
 	"io"
 	"math"
+	"os"
 	"sync"
+	"time"
 
 	errs "github.com/frankli0324/go-http/internal/transport/h2c/errors"
 	"golang.org/x/net/http2"
@@ -17,13 +18,17 @@ type Stream struct {
 	outflow  OutflowCtrl
 	streamID uint32
 
-	// TODO: don't block receiving loop
-	chanHeaders chan *http2.MetaHeadersFrame
-	respWriter  *io.PipeWriter // http2 frame read loop write data
-	respReader  *io.PipeReader // user read data
+	chanHeaders chan *http2.MetaHeadersFrame // queued by frame read loop, never blocks
+	recv        *recvBuffer                  // received DATA, bounded by inflow window
+	sawData     bool                         // accessed only by frame read loop
 
 	rstOnce sync.Once
 
+	// guarded by condOutflow.L, writes blocked by flow control fail after
+	// writeDeadline, which are woken up by writeTimer
+	writeDeadline time.Time
+	writeTimer    *time.Timer
+
 	doneReason error
 	doneOnce   sync.Once
 
@@ -42,16 +47,99 @@ func (s *Stream) Valid() bool {
 	}
 }
 
+// Done returns a channel that's closed when the stream is closed
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason why the stream is closed, nil if closed normally
+func (s *Stream) Err() error {
+	select {
+	case <-s.done:
+		return s.doneReason
+	default:
+		return nil
+	}
+}
+
 func (s *Stream) ID() uint32 {
 	return s.streamID
 }
 
-func (s *Stream) Read(b []byte) (n int, err error) {
-	return 0, errors.ErrUnsupported
+// Read reads the DATA received on the stream, returning the flow-control
+// window to the peer as the data is consumed. It's used for streams carrying
+// a tunnel (rfc9113 8.5), while response bodies are read with
+// [Stream.ResponseBodyStream].
+func (s *Stream) Read(b []byte) (int, error) {
+	n, err := s.recv.Read(b)
+	if n > 0 {
+		s.Connection.refundInflow(s, uint32(n))
+	}
+	return n, err
 }
 
+// Write sends b in DATA frames without ending the stream, it blocks until
+// all the data is written, the stream is closed or the write deadline exceeds.
 func (s *Stream) Write(b []byte) (n int, err error) {
-	return 0, errors.ErrUnsupported
+	for len(b) > 0 {
+		maxWriteFrameSz, done := s.controller.UsePeerSetting(http2.SettingMaxFrameSize)
+		done()
+		chunk := b
+		if len(chunk) > int(maxWriteFrameSz) {
+			chunk = chunk[:maxWriteFrameSz]
+		}
+		w := s.takeOutflow(uint32(len(chunk)))
+		if !s.Valid() {
+			if err = s.Err(); err == nil {
+				err = io.ErrClosedPipe
+			}
+			return n, err
+		}
+		if w == 0 {
+			return n, os.ErrDeadlineExceeded
+		}
+		if err := s.controller.WriteData(s.streamID, false, chunk[:w]); err != nil {
+			return n, errs.ErrFramerWrite.Stream(s.streamID).Wrap(err)
+		}
+		n, b = n+int(w), b[w:]
+	}
+	return n, nil
+}
+
+// SetDeadline sets both the read and write deadlines of the stream,
+// the deadlines of the underlying connection are not touched.
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.recv.SetDeadline(t)
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.condOutflow.L.Lock()
+	defer s.condOutflow.L.Unlock()
+	s.writeDeadline = t
+	if s.writeTimer != nil {
+		s.writeTimer.Stop()
+		s.writeTimer = nil
+	}
+	if d := time.Until(t); !t.IsZero() && d > 0 {
+		s.writeTimer = time.AfterFunc(d, func() {
+			s.condOutflow.L.Lock()
+			s.condOutflow.Broadcast()
+			s.condOutflow.L.Unlock()
+		})
+	}
+	s.condOutflow.Broadcast()
+	return nil
+}
+
+// writeExpired must hold condOutflow.L
+func (s *Stream) writeExpired() bool {
+	return !s.writeDeadline.IsZero() && !time.Now().Before(s.writeDeadline)
 }
 
 func (s *Stream) Close() error {
@@ -63,8 +151,9 @@ func (s *Stream) CloseWithError(err error) error {
 		s.doneReason = err
 		close(s.done)
 		s.Connection.ReleaseStreamID(s)
+		s.condOutflow.Broadcast() // wake up writers blocked by flow control
 		if err != nil {
-			s.respWriter.CloseWithError(err)
+			s.recv.CloseWithError(err)
 		}
 	})
 	return nil
@@ -75,6 +164,9 @@ func (s *Stream) Reset(code http2.ErrCode, isReceived bool) (err error) {
 		if !isReceived {
 			err = s.controller.WriteRSTStream(s.streamID, code)
 			s.CloseWithError(errs.ErrStreamResetLocal(s.streamID, code))
+		} else if code == http2.ErrCodeRefusedStream {
+			// rfc9113 8.7: the stream was closed prior to any processing
+			s.CloseWithError(errs.ErrStreamRefused.Stream(s.streamID).Wrap(errs.ErrStreamResetRemote(s.streamID, code)))
 		} else {
 			s.CloseWithError(errs.ErrStreamResetRemote(s.streamID, code))
 		}
@@ -120,25 +212,88 @@ func (s *Stream) WriteHeaders(ctx context.Context, enumHeaders func(func(k, v st
 				return errs.ErrFramerWrite.Stream(s.streamID).Wrap(err)
 			}
 		}
+		s.Connection.headersSent(s.streamID)
 		return nil
 	})
 }
 
 // TODO: maybe change this api
 func (s *Stream) ReadHeaders(ctx context.Context, headersCb func(k, v string) error) error {
+	var headers *http2.MetaHeadersFrame
 	select {
-	case <-ctx.Done():
-		return errs.ErrStreamCancelled.Stream(s.streamID).Wrap(s.Reset(http2.ErrCodeCancel, false))
-	case <-s.done:
-		return s.doneReason
-	case headers := <-s.chanHeaders:
-		for _, kv := range headers.Fields {
-			if err := headersCb(kv.Name, kv.Value); err != nil {
-				s.Reset(http2.ErrCodeInternal, false)
-				return err
+	case headers = <-s.chanHeaders: // queued headers take precedence over stream close
+	default:
+		select {
+		case <-ctx.Done():
+			return errs.ErrStreamCancelled.Stream(s.streamID).Wrap(s.Reset(http2.ErrCodeCancel, false))
+		case headers = <-s.chanHeaders:
+		case <-s.done:
+			select {
+			case headers = <-s.chanHeaders: // headers with END_STREAM
+			default:
+				if s.doneReason == nil {
+					return io.ErrUnexpectedEOF
+				}
+				return s.doneReason
 			}
 		}
 	}
+	for _, kv := range headers.Fields {
+		if err := headersCb(kv.Name, kv.Value); err != nil {
+			s.Reset(http2.ErrCodeInternal, false)
+			return err
+		}
+	}
+	return nil
+}
+
+// maxQueuedHeaders bounds the HEADERS frames received but not yet read
+// by the application on a single stream
+const maxQueuedHeaders = 8
+
+// receiveHeaders is called by the frame read loop, it never blocks
+func (s *Stream) receiveHeaders(frame *http2.MetaHeadersFrame) error {
+	if s.sawData && !frame.StreamEnded() {
+		// rfc9113 8.1: HEADERS after DATA must be trailers, which end the stream
+		return s.Reset(http2.ErrCodeProtocol, false)
+	}
+	select {
+	case s.chanHeaders <- frame:
+	default:
+		// peer keeps sending HEADERS, e.g. interim responses, faster than consumed
+		return s.Reset(http2.ErrCodeEnhanceYourCalm, false)
+	}
+	if frame.StreamEnded() {
+		s.recv.CloseWithError(io.EOF)
+		s.Close()
+	}
+	return nil
+}
+
+// receiveData is called by the frame read loop, it never blocks. The data is
+// buffered until read by the application, and the window is refunded as
+// the application consumes it.
+func (s *Stream) receiveData(frame *http2.DataFrame) error {
+	s.muInflow.Lock()
+	ok := s.inflow.CheckAndPay(frame.Length)
+	s.muInflow.Unlock()
+	if !ok {
+		// rfc9113 6.9.1: the sender must not exceed the advertised window
+		s.Connection.refundInflow(nil, frame.Length)
+		return s.Reset(http2.ErrCodeFlowControl, false)
+	}
+	s.sawData = true
+	data := frame.Data()
+	if pad := frame.Length - uint32(len(data)); pad > 0 {
+		s.Connection.refundInflow(s, pad) // padding is not buffered
+	}
+	if !s.recv.Write(data) {
+		s.Connection.refundInflow(nil, uint32(len(data)))
+	}
+	if frame.StreamEnded() {
+		s.recv.CloseWithError(io.EOF)
+		s.Close()
+	}
 	return nil
 }
 
@@ -153,9 +308,15 @@ var bodyWriteBuf = (&bufPool{}).init(
 		math.MaxInt},
 )
 
+// takeOutflow returns the size of data allowed to be sent, it returns 0 if the
+// stream is closed or the write deadline exceeds while waiting.
 func (s *Stream) takeOutflow(sz uint32) uint32 {
 	s.condOutflow.L.Lock()
-	for !s.outflow.Available() || !s.Connection.outflow.Available() {
+	for s.writeExpired() || !s.outflow.Available() || !s.Connection.outflow.Available() {
+		if !s.Valid() || s.writeExpired() {
+			s.condOutflow.L.Unlock()
+			return 0
+		}
 		s.condOutflow.Wait()
 	}
 	take1 := s.outflow.Pay(sz)
@@ -192,12 +353,16 @@ func (s *Stream) WriteRequestBody(ctx context.Context, data io.Reader, sz int64,
 		if !sawEOF && current < readThreshold {
 			var l int
 			l, lastRdErr = data.Read(chunk[current:])
-			sawEOF = lastRdErr == io.EOF
+			sawEOF = lastRdErr != nil
 			current += uint32(l)
 			read += int64(l)
 		}
 		w := s.takeOutflow(current)
-		endStream := last && sawEOF && w == current
+		if !s.Valid() {
+			return s.Err()
+		}
+		finished := sawEOF && w == current
+		endStream := last && finished
 		if w > 0 || endStream {
 			if err := s.controller.WriteData(s.streamID, endStream, chunk[:w]); err != nil {
 				return errs.ErrFramerWrite.Stream(s.streamID).Wrap(err)
@@ -208,7 +373,7 @@ func (s *Stream) WriteRequestBody(ctx context.Context, data io.Reader, sz int64,
 		if sz != -1 && read > sz {
 			return errs.ErrReqBodyTooLong.Stream(s.streamID)
 		}
-		if endStream {
+		if finished {
 			if lastRdErr == io.EOF && sz != -1 && read < sz {
 				lastRdErr = io.ErrUnexpectedEOF
 			}
@@ -220,8 +385,55 @@ func (s *Stream) WriteRequestBody(ctx context.Context, data io.Reader, sz int64,
 	}
 }
 
+// readTrailers decodes the trailing HEADERS, it must be called after the
+// body reaches EOF, when the trailers (if any) are already queued.
+func (s *Stream) readTrailers(trailersCb func(k, v string) error) error {
+	for {
+		select {
+		case headers := <-s.chanHeaders:
+			for _, kv := range headers.Fields {
+				if err := trailersCb(kv.Name, kv.Value); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+	}
+}
+
 // TODO: maybe change this api
-func (s *Stream) ResponseBodyStream(ctx context.Context) io.ReadCloser {
-	// TODO: close response reader if error occurrs: remote closed, self cancelled, etc.
-	return s.respReader
+func (s *Stream) ResponseBodyStream(ctx context.Context, trailersCb func(k, v string) error) io.ReadCloser {
+	// TODO: close response reader if self cancelled
+	return &streamBody{s: s, trailersCb: trailersCb}
+}
+
+// streamBody reads the buffered DATA of a stream, returning the flow-control
+// window to the peer as the data is consumed.
+type streamBody struct {
+	s *Stream
+
+	trailersCb func(k, v string) error
+	sawEOF     bool
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.s.Read(p)
+	if err == io.EOF && !b.sawEOF {
+		b.sawEOF = true
+		if b.trailersCb != nil {
+			if terr := b.s.readTrailers(b.trailersCb); terr != nil {
+				return n, terr
+			}
+		}
+	}
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	if n := b.s.recv.CloseRead(); n > 0 {
+		// the stream is gone, only the connection window matters
+		b.s.Connection.refundInflow(nil, uint32(n))
+	}
+	return nil
 }
@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/frankli0324/go-http/internal/http"
+	"github.com/frankli0324/go-http/internal/transport/h2c"
+	"github.com/frankli0324/go-http/internal/transport/http1"
+	"github.com/frankli0324/go-http/utils/netpool"
+)
+
+const (
+	upgradePending = iota
+	upgradeInProgress
+	upgradeRefused
+	upgradeDone
+)
+
+// H2CUpgradeConn starts as an HTTP/1.1 connection, the first request sent over
+// it carries the "Upgrade: h2c" header field. The connection switches to h2c if
+// the server responds with 101 (Switching Protocols), and the response of the
+// first request is carried by stream 1. rfc7540 3.2
+//
+// The connection implements [netpool.MultiplexConn], which serves one session
+// at a time before upgraded, and as many as the peer allows after.
+type H2CUpgradeConn struct {
+	h1  *http1.Conn
+	h2  *H2Conn
+	cfg *h2c.Config
+
+	mu    sync.Mutex
+	state int
+}
+
+func NewH2CUpgradeConn(c net.Conn, cfg *h2c.Config) *H2CUpgradeConn {
+	return &H2CUpgradeConn{h1: &http1.Conn{Conn: c}, cfg: cfg}
+}
+
+func (c *H2CUpgradeConn) Setup(ctx context.Context) error {
+	return c.h1.Setup(ctx)
+}
+
+func (c *H2CUpgradeConn) Capacity() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == upgradeDone {
+		return c.h2.Capacity()
+	}
+	return 1
+}
+
+func (c *H2CUpgradeConn) Session(ctx context.Context, s netpool.Session) (netpool.Session, error) {
+	c.mu.Lock()
+	state := c.state
+	if state == upgradePending {
+		c.state = upgradeInProgress
+	}
+	c.mu.Unlock()
+	switch state {
+	case upgradeDone:
+		return c.h2.Session(ctx, s)
+	case upgradePending:
+		return &h2cUpgradeSession{Sess: s, c: c}, nil
+	default:
+		return c.h1.Session(ctx, s)
+	}
+}
+
+func (c *H2CUpgradeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == upgradeDone {
+		return c.h2.Close()
+	}
+	return c.h1.Close()
+}
+
+// h2cUpgradeSession sends the request which initiates the Upgrade
+type h2cUpgradeSession struct {
+	Sess netpool.Session
+	c    *H2CUpgradeConn
+}
+
+func (s *h2cUpgradeSession) Do(ctx context.Context, req *http.PreparedRequest, resp *http.Response) error {
+	bc := &bufferedConn{Conn: s.c.h1.Conn} // buffered data is taken over after upgraded
+	h2 := NewH2Conn(bc, s.c.cfg)
+	upgradeReq := *req
+	upgradeReq.Header = req.Header.Clone()
+	if upgradeReq.Header == nil {
+		upgradeReq.Header = http.Header{}
+	}
+	upgradeReq.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+	upgradeReq.Header.Set("Upgrade", "h2c")
+	upgradeReq.Header.Set("HTTP2-Settings", h2.UpgradeSettings())
+
+	sess, _ := s.c.h1.Session(ctx, nil)
+	h1 := sess.(*http1.Session)
+	if err := h1.Do(ctx, &upgradeReq, resp); err != nil {
+		s.Release(true)
+		return err
+	}
+	req.Written = upgradeReq.Written
+	if resp.StatusCode != 101 || !strings.EqualFold(resp.Header.Get("Upgrade"), "h2c") {
+		s.c.mu.Lock()
+		s.c.state = upgradeRefused
+		s.c.mu.Unlock()
+		h1.Sess = s.Sess // released when response body is closed
+		return nil
+	}
+	resp.Body.Close()
+
+	_, bc.r = s.c.h1.Hijack()
+	stream, err := h2.UpgradedStream()
+	if err == nil {
+		err = h2.Upgrade()
+	}
+	if err != nil {
+		s.Release(true)
+		return err
+	}
+	s.c.mu.Lock()
+	s.c.h2, s.c.state = h2, upgradeDone
+	s.c.mu.Unlock()
+
+	*resp = http.Response{}
+	hs := &H2Session{Sess: s.Sess, c: h2, stream: stream}
+	return hs.readResponse(ctx, req, resp)
+}
+
+func (s *h2cUpgradeSession) Release(close bool) (reused bool, err error) {
+	if s.Sess == nil {
+		return
+	}
+	reused, err = s.Sess.Release(close)
+	s.Sess = nil
+	return
+}
+
+// bufferedConn reads what's left in the buffer before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if c.r != nil && c.r.Buffered() > 0 {
+		return c.r.Read(b)
+	}
+	return c.Conn.Read(b)
+}
@@ -2,18 +2,21 @@ package http1
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/textproto"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/frankli0324/go-http/internal/http"
 	"github.com/frankli0324/go-http/internal/transport/chunked"
+	"github.com/frankli0324/go-http/utils/coding"
 	"github.com/frankli0324/go-http/utils/netpool"
 )
 
@@ -27,8 +30,14 @@ type Session struct {
 
 	needClose bool
 	reader    io.Reader
+	chunked   interface{ Trailer() http.Header } // set if body is chunked
+	decoders  []io.Closer                        // transfer coding decoders, closed with the body
 	readraw   bool
 	remaining int64 // initially set to content-length
+	sawEOF    bool
+
+	gate       *http.ContinueGate // set if the body waits for 100 (Continue)
+	handedOver bool               // response is read before the body is written
 
 	doneCh     chan error    // doneCh
 	bodyClosed chan struct{} // Signal when body is closed
@@ -69,6 +78,13 @@ func (s *Session) Read(buf []byte) (read int, err error) {
 	} else {
 		read, err = s.reader.Read(buf)
 	}
+	if err == io.EOF {
+		s.sawEOF = true
+		if s.chunked != nil && s.resp.Trailer == nil {
+			s.resp.Trailer = s.chunked.Trailer()
+		}
+		s.closeDecoders()
+	}
 	if s.remaining > 0 {
 		s.remaining -= int64(read)
 	}
@@ -96,6 +112,11 @@ func (s *Session) ReadRaw(buf []byte) (read int, err error) {
 
 // implements ReadCloser
 func (s *Session) Close() (err error) {
+	if !s.sawEOF && s.remaining != 0 {
+		// the rest of the body is still on the wire, the connection can't be reused
+		s.needClose = true
+	}
+	s.closeDecoders()
 	if s.Sess != nil {
 		s.Sess.Release(s.needClose)
 		s.Sess = nil
@@ -105,8 +126,20 @@ func (s *Session) Close() (err error) {
 	}
 	return
 }
+
+// closeDecoders releases the transfer coding decoders, e.g. zstd decoders
+// run goroutines until closed
+func (s *Session) closeDecoders() {
+	for i := len(s.decoders) - 1; i >= 0; i-- {
+		s.decoders[i].Close()
+	}
+	s.decoders = nil
+}
 func (s *Session) writeRequest(_ context.Context) error {
 	r, c := s.req, s.c.Conn
+	if r.Raw != nil {
+		return writeRaw(c, r)
+	}
 	body, err := r.GetBody() // can write body
 	if err != nil {
 		return err
@@ -116,24 +149,36 @@ func (s *Session) writeRequest(_ context.Context) error {
 	}
 	defer body.Close() // request body is ALWAYS closed
 
-	if body != http.NoBody && r.ContentLength == -1 {
-		r.Header.Set("Transfer-Encoding", "chunked")
-	}
-	if err := writeHeader(c, r); err != nil {
+	// trailers could only be sent with chunked transfer coding
+	isChunked := len(r.Trailer) != 0 || (body != http.NoBody && r.ContentLength == -1)
+	if err := writeHeader(c, r, isChunked); err != nil {
 		return err
 	}
-	if body == http.NoBody {
-		return nil
+	if body != http.NoBody && r.ExpectsContinue() {
+		// rfc9110 10.1.1: the body is held until 100 (Continue) is received,
+		// so the response is read before the body is written.
+		s.gate = http.NewContinueGate()
+		s.handedOver = true
+		s.c.rloop <- s
+		send, err := s.gate.Wait(s.ctx)
+		if err != nil {
+			return err
+		}
+		if !send {
+			// the server responded without reading the body, the framing of
+			// the connection is lost
+			return errBodySkipped
+		}
 	}
-	if r.ContentLength == -1 {
+	if isChunked {
 		cw := chunked.NewChunkedWriter(c)
 		if _, err := io.Copy(cw, body); err != nil {
 			return err
 		}
-		if err := cw.CloseWithTrailer(nil); err != nil {
+		if err := cw.CloseWithTrailer(r.Trailer); err != nil {
 			return err
 		}
-	} else {
+	} else if body != http.NoBody {
 		n, err := io.Copy(c, body)
 		if err != nil {
 			return err
@@ -144,24 +189,69 @@ func (s *Session) writeRequest(_ context.Context) error {
 	}
 	return nil
 }
+
+var errBodySkipped = errors.New("request body skipped after final response")
+
 func (s *Session) readResponse(ctx context.Context) (err error) {
-	s.needClose, err = readHeader(ctx, s.c.Reader, s.req, s.resp)
+	for n := 1; ; n++ {
+		s.needClose, err = readHeader(ctx, s.c.Conn, s.c.Reader, s.req, s.resp)
+		if err != nil || !http.IsInterim(s.resp.StatusCode) {
+			break
+		}
+		// interim response, the final response follows
+		if err = http.HandleInterim(ctx, n, s.resp.StatusCode, s.resp.Header); err != nil {
+			s.needClose = true
+			break
+		}
+		s.resp.TransferEncoding = ""
+		if s.gate != nil && s.resp.StatusCode == 100 {
+			s.gate.Continue(true)
+		}
+	}
+	if s.gate != nil {
+		if err != nil {
+			s.gate.Continue(false)
+		} else if s.gate.Continue(false) {
+			s.needClose = true // the body is never sent
+		}
+	}
 	s.remaining = s.resp.ContentLength
 	switch {
 	case s.resp.TransferEncoding != "":
 		s.reader = s.c.Reader
 		// TODO: maybe reading directly from net.Conn is more efficient if going to support more encodings
 		walkReverse(s.resp.TransferEncoding, func(enc string) bool {
-			switch enc {
+			switch enc = strings.ToLower(enc); enc {
 			// apply decoder
 			case "chunked":
-				s.reader = chunked.NewChunkedReader(s.reader)
+				cr := chunked.NewChunkedReader(s.reader)
+				cr.OnExtension(chunked.ExtensionHandlerFromContext(ctx))
+				s.chunked, s.reader = cr, cr
 			default:
-				err = errors.New("unsupported transfer-encoding")
+				// rfc9112 7.2: compression codings, e.g. gzip and deflate
+				dec, derr := coding.NewDecoder(enc, s.reader)
+				if derr != nil && s.req.Raw != nil {
+					if err = newReporter(ctx, s.c.Conn, s.resp)(http.SeverityError, "rfc9112 6.1", "unsupported transfer coding", enc); err != nil {
+						return false
+					}
+					// read as is until the connection is closed
+					s.reader, s.chunked, s.needClose = s.c.Reader, nil, true
+					return false
+				}
+				if derr != nil {
+					err = errors.New("unsupported transfer-encoding")
+					return false
+				}
+				s.reader = dec
+				s.decoders = append(s.decoders, dec)
 			}
 			return true
 		})
-	case s.resp.ContentLength > 0:
+		if err != nil {
+			s.closeDecoders()
+		}
+	case s.resp.ContentLength != 0:
+		// known length, or read until the connection is closed
 		s.readraw = true
 	}
 	s.resp.Body = s
@@ -190,7 +280,7 @@ func (c *Conn) Setup(_ context.Context) error {
 				if !ok {
 					// no more request are going out
 					close(c.rloop)
-					break
+					return
 				}
 				if c.werr != nil {
 					s.doneCh <- fmt.Errorf("connection broken, previous err:%w", c.werr)
@@ -199,11 +289,19 @@ func (c *Conn) Setup(_ context.Context) error {
 				err := s.writeRequest(s.ctx)
 				if err != nil {
 					c.werr = err
+					if s.handedOver {
+						if err != errBodySkipped {
+							c.Conn.Close() // fails the response being read
+						}
+						continue // the read loop reports to the session
+					}
 					s.Release(true)
 					s.doneCh <- err
 					return
 				}
-				c.rloop <- s
+				if !s.handedOver {
+					c.rloop <- s
+				}
 			}
 		}
 	}()
@@ -231,6 +329,37 @@ func (c *Conn) Setup(_ context.Context) error {
 	return nil
 }
 
+// Hijack stops the request loops and hands over the underlying connection
+// together with the data already buffered, e.g. after a 101 (Switching Protocols)
+// response is read. No more sessions could be done on the connection afterwards.
+func (c *Conn) Hijack() (net.Conn, *bufio.Reader) {
+	close(c.wloop)
+	return c.Conn, c.Reader
+}
+
+// Reuse returns a new session over the same connection after the response
+// body is fully consumed, while the connection is kept out of the pool, e.g.
+// for the authentication bound to the connection. It returns nil and releases
+// the session if the connection could not be reused.
+func (s *Session) Reuse() *Session {
+	reusable := (s.sawEOF || s.remaining == 0) && !s.needClose &&
+		(s.resp.ContentLength != -1 || s.resp.TransferEncoding != "") // not delimited by close
+	if !reusable {
+		s.needClose = true
+		s.Close()
+		return nil
+	}
+	s.closeDecoders()
+	next := &Session{Sess: s.Sess, c: s.c}
+	s.Sess = nil
+	return next
+}
+
+// Hijack hands over the connection of the session, see [Conn.Hijack]
+func (s *Session) Hijack() (net.Conn, *bufio.Reader) {
+	return s.c.Hijack()
+}
+
 // mimic stdlib behavior
 func expectContentLength(r *http.PreparedRequest) bool {
 	if r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" {
@@ -248,6 +377,16 @@ func expectContentLength(r *http.PreparedRequest) bool {
 	return false
 }
 
+// trailerKeys returns the value of the "Trailer" header declaring the trailers
+func trailerKeys(trailer http.Header) string {
+	keys := make([]string, 0, len(trailer))
+	for k := range trailer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
 var poolHeaderWriter = sync.Pool{New: func() interface{} { return &bufio.Writer{} }}
 
 // writeHeader writes the status and header part of an http 1.1 request
@@ -257,7 +396,7 @@ var poolHeaderWriter = sync.Pool{New: func() interface{} { return &bufio.Writer{
 //	Host: www.google.com\r\n
 //	X-Xx-Yy: cccccc\r\n
 //	\r\n
-func writeHeader(c net.Conn, r *http.PreparedRequest) error {
+func writeHeader(c net.Conn, r *http.PreparedRequest, isChunked bool) error {
 	header := poolHeaderWriter.Get().(*bufio.Writer)
 	defer poolHeaderWriter.Put(header)
 	defer header.Reset(nil)
@@ -266,24 +405,32 @@ func writeHeader(c net.Conn, r *http.PreparedRequest) error {
 	header.WriteString(r.Method)
 	header.WriteByte(' ')
 	header.WriteString(r.U.RequestURI())
-	header.WriteString(" HTTP/1.1\r\nHost: ")
-	header.WriteString(r.HeaderHost)
-	if r.ContentLength > 0 {
-		header.WriteString("\r\nContent-Length: ")
-		header.WriteString(strconv.FormatInt(r.ContentLength, 10))
-		header.WriteString("\r\n")
-	} else if r.ContentLength == 0 && expectContentLength(r) {
-		header.WriteString("\r\nContent-Length: 0\r\n")
-	} else {
-		header.WriteString("\r\n")
-	}
-
-	for k, v := range r.Header {
-		for _, v := range v {
-			header.WriteString(k)
-			header.WriteString(": ")
-			header.WriteString(v)
-			header.WriteString("\r\n")
+	header.WriteString(" HTTP/1.1\r\n")
+
+	generated := []string{"Host"}
+	if !isChunked && (r.ContentLength > 0 || r.ContentLength == 0 && expectContentLength(r)) {
+		generated = append(generated, "Content-Length")
+	}
+	if len(r.Trailer) != 0 {
+		generated = append(generated, "Trailer")
+	}
+	if isChunked {
+		generated = append(generated, "Transfer-Encoding")
+	}
+	for _, k := range r.HeaderKeys(generated...) {
+		switch k {
+		case "Host":
+			writeField(header, k, r.HeaderHost)
+		case "Transfer-Encoding":
+			writeField(header, k, "chunked")
+		case "Content-Length":
+			writeField(header, k, strconv.FormatInt(r.ContentLength, 10))
+		case "Trailer":
+			writeField(header, k, trailerKeys(r.Trailer))
+		default:
+			for _, v := range r.Header[k] {
+				writeField(header, k, v)
+			}
 		}
 	}
 	header.WriteString("\r\n")
@@ -291,16 +438,58 @@ func writeHeader(c net.Conn, r *http.PreparedRequest) error {
 	return header.Flush()
 }
 
+// writeRaw writes [http.RawRequest] without any modification
+func writeRaw(c net.Conn, r *http.PreparedRequest) error {
+	w := poolHeaderWriter.Get().(*bufio.Writer)
+	defer poolHeaderWriter.Put(w)
+	defer w.Reset(nil)
+	w.Reset(c)
+
+	raw := r.Raw
+	for _, line := range append([]string{raw.RequestLine}, raw.Lines...) {
+		w.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			w.WriteString("\r\n")
+		}
+	}
+	if raw.HeaderEnd == "" {
+		w.WriteString("\r\n")
+	} else {
+		w.WriteString(raw.HeaderEnd)
+	}
+	w.Write(raw.Body)
+	r.Written = true
+	return w.Flush()
+}
+
+func writeField(w *bufio.Writer, k, v string) {
+	w.WriteString(k)
+	w.WriteString(": ")
+	w.WriteString(v)
+	w.WriteString("\r\n")
+}
+
 // readHeader first read headers into resp.Header, and process the response according to RFC.
 // resp.Header will be kept as-is.
-func readHeader(_ context.Context, r *bufio.Reader, req *http.PreparedRequest, resp *http.Response) (close bool, err error) {
-	if err := readRawHeader(textproto.NewReader(r), resp); err != nil {
+func readHeader(ctx context.Context, c net.Conn, r *bufio.Reader, req *http.PreparedRequest, resp *http.Response) (close bool, err error) {
+	// responses to raw requests are parsed leniently, the anomalies are
+	// reported instead of failing the request
+	lenient := req.Raw != nil
+	report := newReporter(ctx, c, resp)
+	if err := readRawHeader(r, resp, http.KeepRawHeader(ctx), lenient, report); err != nil {
 		return true, err
 	}
+	httpver := 0x11
 	if !strings.HasPrefix(resp.Proto, "HTTP/") || len(resp.Proto) != len("HTTP/X.Y") || resp.Proto[6] != '.' {
-		return true, errors.New("malformed HTTP version")
+		if !lenient {
+			return true, errors.New("malformed HTTP version")
+		}
+		if err := report(http.SeverityError, "rfc9112 2.3", "malformed HTTP version", resp.Proto); err != nil {
+			return true, err
+		}
+	} else {
+		httpver = int(resp.Proto[5]-'0')<<4 | int(resp.Proto[7]-'0')
 	}
-	httpver := int(resp.Proto[5]-'0')<<4 | int(resp.Proto[7]-'0')
 
 	// the header key was canonicalized while reading from the stream
 	contentLens := resp.Header["Content-Length"]
@@ -332,6 +521,9 @@ func readHeader(_ context.Context, r *bufio.Reader, req *http.PreparedRequest, r
 				return true
 			})
 		}
+		if err != nil && lenient {
+			err = report(http.SeverityError, "rfc9112 6.3", err.Error(), strings.Join(contentLens, ", "))
+		}
 		if err != nil {
 			return true, err
 		}
@@ -339,11 +531,17 @@ func readHeader(_ context.Context, r *bufio.Reader, req *http.PreparedRequest, r
 		// Logic based on Content-Length
 		var n uint64
 		n, err = strconv.ParseUint(first, 10, 63)
-		if err != nil {
+		if err == nil {
+			resp.ContentLength = int64(n)
+		} else if lenient {
+			// read until the connection is closed
+			if err = report(http.SeverityError, "rfc9112 6.3", "invalid Content-Length", contentLens[0]); err != nil {
+				return true, err
+			}
+		} else {
 			err = errors.New("invalid content-length response header: " + contentLens[0])
 			return
 		}
-		resp.ContentLength = int64(n)
 	}
 
 	transferEnc := resp.Header["Transfer-Encoding"]
@@ -372,10 +570,18 @@ func readHeader(_ context.Context, r *bufio.Reader, req *http.PreparedRequest, r
 			resp.TransferEncoding += textproto.TrimString(transferEnc[i])
 		}
 		if resp.TransferEncoding == "" {
-			// TODO: audit(warn), empty Transfer-Encoding header
+			// rfc9112 6.1: Transfer-Encoding = #transfer-coding, which is
+			// not empty as a required list
+			if err := report(http.SeverityWarning, "rfc9112 6.1", "empty Transfer-Encoding", strings.Join(transferEnc, ", ")); err != nil {
+				return true, err
+			}
 		}
 		if httpver < 11 {
-			// TODO: audit, Transfer-Encoding header present in HTTP/1.0
+			// rfc9112 6.1: a server MUST NOT send a response containing
+			// Transfer-Encoding unless the request indicates HTTP/1.1
+			if err := report(http.SeverityWarning, "rfc9112 6.1", "Transfer-Encoding in "+resp.Proto+" response", resp.TransferEncoding); err != nil {
+				return true, err
+			}
 			resp.TransferEncoding = ""
 		}
 	}
@@ -387,8 +593,12 @@ func readHeader(_ context.Context, r *bufio.Reader, req *http.PreparedRequest, r
 	// the first empty line after the header fields, regardless of the header fields
 	// present in the message, and thus cannot contain a message body or trailer section.
 	if req.Method == "HEAD" || resp.StatusCode/100 == 1 || resp.StatusCode == 204 || resp.StatusCode == 304 {
-		if resp.ContentLength > 0 {
-			// TODO: audit
+		// rfc9110 8.6: a server MAY send Content-Length in a response to HEAD
+		// or in a 304, but MUST NOT in a 1xx or 204 response
+		if len(contentLens) > 0 && (resp.StatusCode/100 == 1 || resp.StatusCode == 204) {
+			if err := report(http.SeverityWarning, "rfc9110 8.6", "Content-Length in "+strconv.Itoa(resp.StatusCode)+" response", contentLens[0]); err != nil {
+				return true, err
+			}
 		}
 		resp.ContentLength = 0 // not -1, which means "unknwon"
 	}
@@ -412,6 +622,13 @@ func readHeader(_ context.Context, r *bufio.Reader, req *http.PreparedRequest, r
 	// Content-Length field and process the Transfer-Encoding (as described below)
 	// prior to forwarding the message downstream.
 	if resp.TransferEncoding != "" {
+		if len(contentLens) > 0 {
+			if err := report(http.SeverityError, "rfc9112 6.3", "both Transfer-Encoding and Content-Length present", contentLens[0]); err != nil {
+				return true, err
+			}
+			// rfc9112 11.2: the connection is not reused to avoid smuggling
+			close = true
+		}
 		resp.ContentLength = -1
 
 		// 4.If a Transfer-Encoding header field is present ...
@@ -437,7 +654,8 @@ func readHeader(_ context.Context, r *bufio.Reader, req *http.PreparedRequest, r
 	return
 }
 
-func readRawHeader(tp *textproto.Reader, resp *http.Response) error {
+func readRawHeader(r *bufio.Reader, resp *http.Response, keepRaw, lenient bool, report reporter) error {
+	tp := textproto.NewReader(r)
 	line, err := tp.ReadLine()
 	if err != nil {
 		if err == io.EOF {
@@ -462,13 +680,32 @@ func readRawHeader(tp *textproto.Reader, resp *http.Response) error {
 	}
 
 	// Parse the response headers. There are cases where case sensitivity
-	// is needed, but is ignored intentionally in this implementation.
-	mimeHeader, err := tp.ReadMIMEHeader()
-	if err != nil {
-		if err == io.EOF {
-			err = io.ErrUnexpectedEOF
+	// is needed, which is kept in resp.RawHeader if requested.
+	resp.RawHeader = nil
+	var mimeHeader textproto.MIMEHeader
+	if keepRaw || lenient {
+		block, err := readHeaderBlock(r)
+		if err != nil {
+			return err
+		}
+		if keepRaw {
+			resp.RawHeader = splitHeaderBlock(block)
+		}
+		if lenient {
+			if mimeHeader, err = parseHeaderLenient(block, report); err != nil {
+				return err
+			}
+		} else {
+			tp = textproto.NewReader(bufio.NewReader(bytes.NewReader(block)))
+		}
+	}
+	if mimeHeader == nil {
+		if mimeHeader, err = tp.ReadMIMEHeader(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
 		}
-		return err
 	}
 	if hp, ok := mimeHeader["Pragma"]; ok && len(hp) > 0 && hp[0] == "no-cache" {
 		if _, presentcc := mimeHeader["Cache-Control"]; !presentcc {
@@ -478,3 +715,98 @@ func readRawHeader(tp *textproto.Reader, resp *http.Response) error {
 	resp.Header = http.Header(mimeHeader)
 	return nil
 }
+
+// readHeaderBlock reads the header field lines including the empty line
+// terminating them as is
+func readHeaderBlock(r *bufio.Reader) ([]byte, error) {
+	var block []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		block = append(block, line...)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return block, nil
+		}
+	}
+}
+
+// splitHeaderBlock splits the header block into fields, names are kept as
+// is while values are trimmed of surrounding whitespaces. obs-fold lines are
+// joined to the previous value with a space. rfc9112 5.2
+func splitHeaderBlock(block []byte) (fields []http.HeaderField) {
+	for _, line := range strings.Split(string(block), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) != 0 {
+			last := &fields[len(fields)-1]
+			last.Value += " " + strings.Trim(line, " \t")
+			continue
+		}
+		name, value, _ := Cut(line, ":")
+		fields = append(fields, http.HeaderField{Name: name, Value: strings.Trim(value, " \t")})
+	}
+	return
+}
+
+// parseHeaderLenient parses the header block, tolerating the malformed
+// field lines which are reported as anomalies
+func parseHeaderLenient(block []byte, report reporter) (textproto.MIMEHeader, error) {
+	header := make(textproto.MIMEHeader)
+	lines := strings.Split(string(block), "\n")
+	last := ""
+	for _, line := range lines[:len(lines)-1] { // the block ends with "\n"
+		if strings.HasSuffix(line, "\r") {
+			line = line[:len(line)-1]
+		} else {
+			if err := report(http.SeverityWarning, "rfc9112 2.2", "bare LF line terminator", line); err != nil {
+				return nil, err
+			}
+		}
+		if line == "" {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if err := report(http.SeverityWarning, "rfc9112 5.2", "obsolete line folding", line); err != nil {
+				return nil, err
+			}
+			if vs := header[last]; len(vs) != 0 {
+				vs[len(vs)-1] += " " + strings.Trim(line, " \t")
+			}
+			continue
+		}
+		name, value, ok := Cut(line, ":")
+		if !ok || name == "" {
+			if err := report(http.SeverityError, "rfc9112 5.1", "malformed field line", line); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if trimmed := strings.TrimRight(name, " \t"); trimmed != name {
+			if err := report(http.SeverityError, "rfc9112 5.1", "whitespace between field name and colon", name); err != nil {
+				return nil, err
+			}
+			name = trimmed
+		}
+		last = textproto.CanonicalMIMEHeaderKey(name)
+		header[last] = append(header[last], strings.Trim(value, " \t"))
+	}
+	return header, nil
+}
+
+// reporter reports an anomaly of the response, see [http.Report]
+type reporter func(severity http.Severity, section, message, value string) error
+
+func newReporter(ctx context.Context, c net.Conn, resp *http.Response) reporter {
+	return func(severity http.Severity, section, message, value string) error {
+		return http.Report(ctx, resp, http.Anomaly{
+			Severity: severity, Section: section, Message: message, Value: value, Conn: c,
+		})
+	}
+}
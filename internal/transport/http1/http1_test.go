@@ -2,8 +2,10 @@ package http1_test
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net"
+	"reflect"
 	"strings"
 	"testing"
 	"testing/iotest"
@@ -56,6 +58,66 @@ var reqShouldBe = map[string]tCase{
 		},
 		data: []byte("GET /?test=1 HTTP/1.1\r\nHost: www.example.com\r\n\r\n"),
 	},
+	"ChunkedWithTrailer": {
+		req: &http.Request{
+			Method:  "POST",
+			URL:     "http://www.example.com/",
+			Body:    io.MultiReader(strings.NewReader("hello")),
+			Trailer: http.Header{"X-Sum": {"1"}},
+		},
+		data: []byte("POST / HTTP/1.1\r\nHost: www.example.com\r\nTrailer: X-Sum\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"5\r\nhello\r\n0\r\nX-Sum: 1\r\n\r\n"),
+	},
+	"HeaderSorted": {
+		req: &http.Request{
+			Method: "GET",
+			URL:    "http://www.example.com/",
+			Header: http.Header{"X-C": {"3"}, "X-A": {"1"}, "X-B": {"2", "2"}},
+		},
+		data: []byte("GET / HTTP/1.1\r\nHost: www.example.com\r\nX-A: 1\r\nX-B: 2\r\nX-B: 2\r\nX-C: 3\r\n\r\n"),
+	},
+	"HeaderOrder": {
+		req: &http.Request{
+			Method:      "POST",
+			URL:         "http://www.example.com/",
+			Header:      http.Header{"X-C": {"3"}, "X-A": {"1"}, "User-Agent": {"ua"}},
+			HeaderOrder: []string{"user-agent", "content-length", "x-c", "host"},
+			Body:        "hi",
+		},
+		data: []byte("POST / HTTP/1.1\r\nUser-Agent: ua\r\nContent-Length: 2\r\nX-C: 3\r\nHost: www.example.com\r\nX-A: 1\r\n\r\nhi"),
+	},
+	"HeaderOrderGenerated": {
+		// the user field is replaced by the generated one, at the listed position
+		req: &http.Request{
+			Method:      "POST",
+			URL:         "http://www.example.com/",
+			Header:      http.Header{"transfer-encoding": {"gzip"}, "X-A": {"1"}},
+			HeaderOrder: []string{"x-a", "transfer-encoding", "host"},
+			Body:        io.MultiReader(strings.NewReader("hello")),
+		},
+		data: []byte("POST / HTTP/1.1\r\nX-A: 1\r\nTransfer-Encoding: chunked\r\nHost: www.example.com\r\n\r\n" +
+			"5\r\nhello\r\n0\r\n\r\n"),
+	},
+	"RawRequest": {
+		req: &http.Request{
+			URL:    "http://www.example.com/ignored",
+			Header: http.Header{"X-Ignored": {"1"}},
+			Raw: &http.RawRequest{
+				RequestLine: "POST /raw HTTP/1.1",
+				Lines: []string{
+					"host: www.example.com",
+					"Content-Length: 3",
+					"Content-Length: 5\n",
+					"Transfer-Encoding: chunked",
+					"X-Folded: a",
+					" b",
+				},
+				Body: []byte("0\r\n\r\n"),
+			},
+		},
+		data: []byte("POST /raw HTTP/1.1\r\nhost: www.example.com\r\nContent-Length: 3\r\nContent-Length: 5\n" +
+			"Transfer-Encoding: chunked\r\nX-Folded: a\r\n b\r\n\r\n0\r\n\r\n"),
+	},
 }
 
 func TestRequestSerialize(t *testing.T) {
@@ -124,3 +186,153 @@ func SendSingleRequest(t *testing.T, req *http.Request) io.Reader {
 	}()
 	return readRequest
 }
+
+func TestResponseTrailer(t *testing.T) {
+	c := &internal.Client{}
+	c.UseDialer(func(dialer.Dialer) dialer.Dialer {
+		return &TestDialer{CombinedReadWriteCloser{
+			Reader: strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+				"5\r\nhello\r\n0\r\nX-Sum: 1\r\nX-Other: 2\r\n\r\n"),
+			Writer: io.Discard,
+			Closer: io.NopCloser(nil),
+		}}
+	})
+	resp, err := c.CtxDo(context.Background(), &http.Request{Method: "GET", URL: "http://www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Trailer != nil {
+		t.Error("trailers available before body EOF")
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("unexpected body %q, err %v", b, err)
+	}
+	if resp.Trailer.Get("X-Sum") != "1" || resp.Trailer.Get("X-Other") != "2" {
+		t.Errorf("unexpected trailers %v", resp.Trailer)
+	}
+}
+
+func TestResponseRawHeader(t *testing.T) {
+	c := &internal.Client{}
+	c.UseDialer(func(dialer.Dialer) dialer.Dialer {
+		return &TestDialer{CombinedReadWriteCloser{
+			Reader: strings.NewReader("HTTP/1.1 100 Continue\r\nX-Interim: 1\r\n\r\n" +
+				"HTTP/1.1 200 OK\r\nx-lower: a\r\nSet-Cookie: a=1\r\nX-Folded: b\r\n  c\r\nset-cookie: b=2\r\nContent-Length: 2\r\n\r\nok"),
+			Writer: io.Discard,
+			Closer: io.NopCloser(nil),
+		}}
+	})
+	ctx := http.WithRawHeader(context.Background())
+	resp, err := c.CtxDo(ctx, &http.Request{Method: "GET", URL: "http://www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	want := []http.HeaderField{
+		{Name: "x-lower", Value: "a"},
+		{Name: "Set-Cookie", Value: "a=1"},
+		{Name: "X-Folded", Value: "b c"},
+		{Name: "set-cookie", Value: "b=2"},
+		{Name: "Content-Length", Value: "2"},
+	}
+	if !reflect.DeepEqual(resp.RawHeader, want) {
+		t.Errorf("unexpected raw header %q", resp.RawHeader)
+	}
+	if got := resp.Header.Values("Set-Cookie"); !reflect.DeepEqual(got, []string{"a=1", "b=2"}) {
+		t.Errorf("unexpected canonical header %q", got)
+	}
+	if b, _ := io.ReadAll(resp.Body); string(b) != "ok" {
+		t.Errorf("unexpected body %q", b)
+	}
+}
+
+func TestRawRequestLenientResponse(t *testing.T) {
+	response := "HTTP/1.1 200 OK\r\nContent-Length: 2\nContent-Length: 3\r\nX-Folded: a\r\n b\r\n" +
+		"X-Space : c\r\nmalformed\r\n\r\nok"
+	c := &internal.Client{}
+	c.UseDialer(func(dialer.Dialer) dialer.Dialer {
+		return &TestDialer{CombinedReadWriteCloser{
+			Reader: strings.NewReader(response),
+			Writer: io.Discard,
+			Closer: io.NopCloser(nil),
+		}}
+	})
+	if _, err := c.CtxDo(context.Background(), &http.Request{Method: "GET", URL: "http://www.example.com"}); err == nil {
+		t.Fatal("malformed response accepted without raw mode")
+	}
+
+	c.UseDialer(func(dialer.Dialer) dialer.Dialer {
+		return &TestDialer{CombinedReadWriteCloser{
+			Reader: strings.NewReader(response),
+			Writer: io.Discard,
+			Closer: io.NopCloser(nil),
+		}}
+	})
+	resp, err := c.CtxDo(context.Background(), &http.Request{
+		URL: "http://www.example.com",
+		Raw: &http.RawRequest{RequestLine: "GET / HTTP/1.1", Lines: []string{"Host: www.example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); string(b) != "ok" {
+		t.Errorf("unexpected body %q", b)
+	}
+	if resp.Header.Get("X-Folded") != "a b" || resp.Header.Get("X-Space") != "c" {
+		t.Errorf("unexpected header %v", resp.Header)
+	}
+	var sections []string
+	for _, a := range resp.Anomalies {
+		sections = append(sections, a.Section)
+	}
+	want := []string{"rfc9112 2.2", "rfc9112 5.2", "rfc9112 5.1", "rfc9112 5.1", "rfc9112 6.3"}
+	if !reflect.DeepEqual(sections, want) {
+		t.Errorf("unexpected anomalies %v", resp.Anomalies)
+	}
+}
+
+func TestResponseAudit(t *testing.T) {
+	newClient := func() *internal.Client {
+		c := &internal.Client{}
+		c.UseDialer(func(dialer.Dialer) dialer.Dialer {
+			return &TestDialer{CombinedReadWriteCloser{
+				Reader: strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\nTransfer-Encoding: chunked\r\n\r\n" +
+					"2\r\nok\r\n0\r\n\r\n"),
+				Writer: io.Discard,
+				Closer: io.NopCloser(nil),
+			}}
+		})
+		return c
+	}
+	var found []http.Anomaly
+	c := newClient()
+	c.UseAudit(&http.AuditConfig{Sink: func(a http.Anomaly) { found = append(found, a) }})
+	resp, err := c.CtxDo(context.Background(), &http.Request{Method: "GET", URL: "http://www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(resp.Body); string(b) != "ok" {
+		t.Errorf("unexpected body %q", b)
+	}
+	resp.Body.Close()
+	if len(found) != 1 || found[0].Severity != http.SeverityError || found[0].Section != "rfc9112 6.3" ||
+		found[0].Value != "10" || found[0].Conn == nil {
+		t.Fatalf("unexpected anomalies %v", found)
+	}
+	if !reflect.DeepEqual(resp.Anomalies, found) {
+		t.Errorf("anomalies not recorded in response: %v", resp.Anomalies)
+	}
+
+	// strict mode in the context takes precedence over the client
+	ctx := http.WithAudit(context.Background(), &http.AuditConfig{
+		Strict: func(a http.Anomaly) bool { return a.Severity >= http.SeverityError },
+	})
+	_, err = newClient().CtxDo(ctx, &http.Request{Method: "GET", URL: "http://www.example.com"})
+	var anomaly *http.AnomalyError
+	if !errors.As(err, &anomaly) || anomaly.Anomaly.Section != "rfc9112 6.3" {
+		t.Errorf("expected anomaly error, got %v", err)
+	}
+}
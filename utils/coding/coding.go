@@ -0,0 +1,186 @@
+// package coding holds the content codings (rfc9110 8.4.1) used to compress
+// and decompress message bodies. gzip, deflate, br and zstd are supported out
+// of box, others could be registered with [RegisterDecoder] and [RegisterEncoder].
+package coding
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Decoder creates a reader decompressing r
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+// Encoder creates a writer compressing into w, the writer must be closed
+// to flush the compressed data.
+type Encoder func(w io.Writer) (io.WriteCloser, error)
+
+var ErrUnsupported = errors.New("unsupported coding")
+
+var (
+	mu       sync.RWMutex
+	decoders = map[string]Decoder{}
+	encoders = map[string]Encoder{}
+	names    []string // decoders in registration order
+)
+
+func init() {
+	RegisterDecoder("gzip", func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) })
+	RegisterDecoder("deflate", newDeflateReader)
+	RegisterDecoder("br", func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil })
+	RegisterDecoder("zstd", newZstdReader)
+	RegisterEncoder("gzip", func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil })
+	RegisterEncoder("deflate", func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil })
+	RegisterEncoder("br", func(w io.Writer) (io.WriteCloser, error) { return brotli.NewWriter(w), nil })
+	RegisterEncoder("zstd", func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdMaxWindow))
+	})
+}
+
+// RegisterDecoder registers or replaces the decoder of the coding name,
+// which is case-insensitive.
+func RegisterDecoder(name string, d Decoder) {
+	name = strings.ToLower(name)
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := decoders[name]; !ok {
+		names = append(names, name)
+	}
+	decoders[name] = d
+}
+
+// RegisterEncoder registers or replaces the encoder of the coding name,
+// which is case-insensitive.
+func RegisterEncoder(name string, e Encoder) {
+	mu.Lock()
+	encoders[strings.ToLower(name)] = e
+	mu.Unlock()
+}
+
+// Decoders returns the names of registered decoders in registration order
+func Decoders() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]string(nil), names...)
+}
+
+// Supported reports whether the coding could be decoded
+func Supported(name string) bool {
+	name = normalize(name)
+	if name == "identity" {
+		return true
+	}
+	mu.RLock()
+	_, ok := decoders[name]
+	mu.RUnlock()
+	return ok
+}
+
+// CanEncode reports whether the coding could be encoded
+func CanEncode(name string) bool {
+	mu.RLock()
+	_, ok := encoders[normalize(name)]
+	mu.RUnlock()
+	return ok
+}
+
+// x-gzip is equivalent to gzip. rfc9110 8.4.1.3
+func normalize(name string) string {
+	name = strings.ToLower(name)
+	if name == "x-gzip" {
+		return "gzip"
+	}
+	return name
+}
+
+// NewDecoder returns a reader decompressing r with the coding. The decoder
+// is created lazily on the first read, so that no data is read from r
+// before the body is read.
+func NewDecoder(name string, r io.Reader) (io.ReadCloser, error) {
+	name = normalize(name)
+	if name == "identity" {
+		return io.NopCloser(r), nil
+	}
+	mu.RLock()
+	d, ok := decoders[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return &lazyDecoder{r: r, new: d}, nil
+}
+
+// NewEncoder returns a writer compressing into w with the coding
+func NewEncoder(name string, w io.Writer) (io.WriteCloser, error) {
+	mu.RLock()
+	e, ok := encoders[normalize(name)]
+	mu.RUnlock()
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return e(w)
+}
+
+type lazyDecoder struct {
+	r   io.Reader
+	new Decoder
+
+	dec io.ReadCloser
+	err error
+}
+
+func (l *lazyDecoder) Read(p []byte) (int, error) {
+	if l.dec == nil && l.err == nil {
+		l.dec, l.err = l.new(l.r)
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	return l.dec.Read(p)
+}
+
+func (l *lazyDecoder) Close() error {
+	if l.dec != nil {
+		return l.dec.Close()
+	}
+	return nil
+}
+
+// "deflate" is the zlib format (rfc9110 8.4.1.2), however some servers send
+// raw deflate data without the zlib header, which is tolerated as well.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	// rfc1950 2.2: CM is 8 and the header checksum is a multiple of 31
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// zstdMaxWindow is the largest window of the zstd content coding, so that
+// the memory used by the decoder is bounded. rfc8878 3.1.1.1.2
+const zstdMaxWindow = 8 << 20
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
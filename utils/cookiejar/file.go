@@ -0,0 +1,75 @@
+package cookiejar
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// load reads persistent cookies from the file, missing file is not an error
+func (j *Jar) load() error {
+	b, err := os.ReadFile(j.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var entries []*entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	now := j.now()
+	for _, e := range entries {
+		if !e.Persistent || e.expired(now) {
+			continue
+		}
+		j.seq++
+		e.seq = j.seq
+		j.entries[e.id()] = e
+	}
+	return nil
+}
+
+// Save writes the persistent cookies to [Options.Filename], it's called
+// automatically when persistent cookies change, and returns the error
+// of writing the file if any. Session cookies are never saved.
+func (j *Jar) Save() error {
+	if j.filename == "" {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.save()
+}
+
+func (j *Jar) save() error {
+	now := j.now()
+	entries := make([]*entry, 0, len(j.entries))
+	for _, e := range j.entries {
+		if e.Persistent && !e.expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].seq < entries[b].seq })
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so the file is never half written
+	f, err := os.CreateTemp(filepath.Dir(j.filename), filepath.Base(j.filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), j.filename)
+}
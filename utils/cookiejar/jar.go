@@ -0,0 +1,337 @@
+// package cookiejar implements an in-memory cookie jar following rfc6265bis,
+// which could optionally be backed by a file to persist cookies across runs.
+package cookiejar
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// PublicSuffixList provides the public suffix of a domain, which prevents
+// cookies from being set for domains like "co.uk". See [publicsuffix.List].
+type PublicSuffixList interface {
+	PublicSuffix(domain string) string
+}
+
+type Options struct {
+	// PublicSuffixList defaults to [publicsuffix.List]
+	PublicSuffixList PublicSuffixList
+
+	// Filename is where persistent cookies are loaded from and saved to,
+	// the file is rewritten each time the persistent cookies change.
+	// cookies are only kept in memory if empty.
+	Filename string
+}
+
+// Jar implements [github.com/frankli0324/go-http/internal/http.CookieJar]
+type Jar struct {
+	psList   PublicSuffixList
+	filename string
+
+	mu      sync.Mutex
+	entries map[string]*entry // keyed by entry.id()
+	seq     uint64            // breaks ties of creation time
+
+	now func() time.Time
+}
+
+// New creates a jar, persistent cookies are loaded from [Options.Filename]
+// if it exists.
+func New(o *Options) (*Jar, error) {
+	j := &Jar{
+		psList:  publicsuffix.List,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+	if o != nil {
+		if o.PublicSuffixList != nil {
+			j.psList = o.PublicSuffixList
+		}
+		j.filename = o.Filename
+	}
+	if j.filename != "" {
+		if err := j.load(); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// entry is a stored cookie. rfc6265bis 5.7
+type entry struct {
+	Name     string        `json:"name"`
+	Value    string        `json:"value"`
+	Domain   string        `json:"domain"`
+	Path     string        `json:"path"`
+	SameSite http.SameSite `json:"same_site"`
+	Secure   bool          `json:"secure"`
+	HttpOnly bool          `json:"http_only"`
+	HostOnly bool          `json:"host_only"`
+
+	Persistent bool      `json:"persistent"`
+	Expires    time.Time `json:"expires"`
+	Creation   time.Time `json:"creation"`
+	LastAccess time.Time `json:"last_access"`
+
+	seq uint64
+}
+
+func (e *entry) id() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.Persistent && !e.Expires.After(now)
+}
+
+// rfc6265bis 5.1.3
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	return strings.HasSuffix(host, domain) && host[len(host)-len(domain)-1] == '.' && net.ParseIP(host) == nil
+}
+
+// rfc6265bis 5.1.4
+func pathMatch(reqPath, cookiePath string) bool {
+	if reqPath == cookiePath {
+		return true
+	}
+	if strings.HasPrefix(reqPath, cookiePath) {
+		return cookiePath[len(cookiePath)-1] == '/' || reqPath[len(cookiePath)] == '/'
+	}
+	return false
+}
+
+// rfc6265bis 5.1.4: default-path
+func defaultPath(reqPath string) string {
+	if reqPath == "" || reqPath[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(reqPath, "/")
+	if i == 0 {
+		return "/"
+	}
+	return reqPath[:i]
+}
+
+func canonicalHost(u *url.URL) string {
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+func isSecure(u *url.URL) bool {
+	// rfc6265bis 5.8.3: "secure" includes https and loopback origins
+	if u.Scheme == "https" || u.Scheme == "wss" {
+		return true
+	}
+	host := canonicalHost(u)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// registrableDomain returns eTLD+1 of host, or host itself if it's an IP
+// address or a public suffix
+func (j *Jar) registrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	suffix := j.psList.PublicSuffix(host)
+	if suffix == host || len(host) <= len(suffix) {
+		return host
+	}
+	i := strings.LastIndex(host[:len(host)-len(suffix)-1], ".")
+	return host[i+1:]
+}
+
+// sameSite reports whether u and site are schemeful same-site. rfc6265bis 5.2
+func (j *Jar) sameSite(u, site *url.URL) bool {
+	if site == nil {
+		return true
+	}
+	return isSecure(u) == isSecure(site) &&
+		j.registrableDomain(canonicalHost(u)) == j.registrableDomain(canonicalHost(site))
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// Cookies returns the cookies to send in a request to u. rfc6265bis 5.8.3
+//
+// requests sent by the client are treated as top-level navigations, so that
+// "Lax" cookies are sent in cross-site requests with safe methods, while
+// "Strict" cookies are only sent in same-site requests.
+func (j *Jar) Cookies(u, site *url.URL, method string) (cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	host, secure := canonicalHost(u), isSecure(u)
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	sameSite := j.sameSite(u, site)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := j.now()
+	var selected []*entry
+	for id, e := range j.entries {
+		if e.expired(now) {
+			delete(j.entries, id)
+			continue
+		}
+		if e.HostOnly && host != e.Domain || !e.HostOnly && !domainMatch(host, e.Domain) {
+			continue
+		}
+		if !pathMatch(path, e.Path) || e.Secure && !secure {
+			continue
+		}
+		if !sameSite && (e.SameSite == http.SameSiteStrictMode ||
+			e.SameSite == http.SameSiteLaxMode && !isSafeMethod(method)) {
+			continue
+		}
+		selected = append(selected, e)
+	}
+	// longer paths first, then earlier creation times
+	sort.Slice(selected, func(a, b int) bool {
+		ea, eb := selected[a], selected[b]
+		if len(ea.Path) != len(eb.Path) {
+			return len(ea.Path) > len(eb.Path)
+		}
+		if !ea.Creation.Equal(eb.Creation) {
+			return ea.Creation.Before(eb.Creation)
+		}
+		return ea.seq < eb.seq
+	})
+	for _, e := range selected {
+		e.LastAccess = now
+		cookies = append(cookies, &http.Cookie{Name: e.Name, Value: e.Value})
+	}
+	return cookies
+}
+
+// maxCookieAge caps the expiry of cookies. rfc6265bis 5.6.1
+const maxCookieAge = 400 * 24 * time.Hour
+
+// SetCookies stores the cookies received in the response of u,
+// invalid cookies are ignored. rfc6265bis 5.7
+func (j *Jar) SetCookies(u, site *url.URL, cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	changed := false
+	for _, c := range cookies {
+		if j.setCookie(u, c) {
+			changed = true
+		}
+	}
+	if changed && j.filename != "" {
+		j.save() // best effort, Jar.Save reports the error
+	}
+}
+
+func (j *Jar) setCookie(u *url.URL, c *http.Cookie) (persistentChanged bool) {
+	if c.Name == "" && c.Value == "" || len(c.Name)+len(c.Value) > 4096 {
+		return false
+	}
+	host, now := canonicalHost(u), j.now()
+	e := &entry{
+		Name: c.Name, Value: c.Value,
+		Secure: c.Secure, HttpOnly: c.HttpOnly,
+		SameSite: c.SameSite,
+		Creation: now, LastAccess: now,
+	}
+
+	// Max-Age takes precedence over Expires. rfc6265bis 5.6.2
+	switch {
+	case c.MaxAge < 0:
+		e.Persistent, e.Expires = true, time.Unix(0, 0)
+	case c.MaxAge > 0:
+		e.Persistent, e.Expires = true, now.Add(time.Duration(c.MaxAge)*time.Second)
+	case !c.Expires.IsZero():
+		e.Persistent, e.Expires = true, c.Expires
+	}
+	if e.Persistent && e.Expires.After(now.Add(maxCookieAge)) {
+		e.Expires = now.Add(maxCookieAge)
+	}
+
+	domain := strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+	if domain != "" && j.psList.PublicSuffix(domain) == domain {
+		if domain != host {
+			return false // public suffixes are not allowed as the domain attribute
+		}
+		domain = ""
+	}
+	if domain != "" {
+		if !domainMatch(host, domain) {
+			return false
+		}
+		e.Domain = domain
+	} else {
+		e.HostOnly, e.Domain = true, host
+	}
+
+	e.Path = c.Path
+	if e.Path == "" || e.Path[0] != '/' {
+		e.Path = defaultPath(u.EscapedPath())
+	}
+
+	secure := isSecure(u)
+	if e.Secure && !secure {
+		return false
+	}
+	// rfc6265bis 4.1.3: cookie name prefixes
+	if strings.HasPrefix(c.Name, "__Secure-") && !e.Secure {
+		return false
+	}
+	if strings.HasPrefix(c.Name, "__Host-") && (!e.Secure || !e.HostOnly || e.Path != "/") {
+		return false
+	}
+	// rfc6265bis 5.7: SameSite=None requires Secure
+	if e.SameSite == http.SameSiteNoneMode && !e.Secure {
+		return false
+	}
+
+	if !secure {
+		// rfc6265bis 5.7: insecure origins must not overlay existing secure cookies
+		for _, old := range j.entries {
+			if old.Secure && old.Name == e.Name && !old.expired(now) &&
+				(domainMatch(e.Domain, old.Domain) || domainMatch(old.Domain, e.Domain)) &&
+				pathMatch(e.Path, old.Path) {
+				return false
+			}
+		}
+	}
+
+	id := e.id()
+	old, ok := j.entries[id]
+	if ok {
+		e.Creation, e.seq = old.Creation, old.seq
+	} else {
+		j.seq++
+		e.seq = j.seq
+	}
+	if e.expired(now) {
+		delete(j.entries, id)
+		return ok && old.Persistent
+	}
+	j.entries[id] = e
+	return e.Persistent || ok && old.Persistent
+}
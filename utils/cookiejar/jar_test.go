@@ -0,0 +1,136 @@
+package cookiejar
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func cookieString(cookies []*http.Cookie) string {
+	s := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		s = append(s, c.String())
+	}
+	return strings.Join(s, "; ")
+}
+
+func parseSetCookies(lines ...string) []*http.Cookie {
+	return (&http.Response{Header: http.Header{"Set-Cookie": lines}}).Cookies()
+}
+
+func TestJarDomainAndPath(t *testing.T) {
+	j, _ := New(nil)
+	from := mustParse(t, "https://www.example.com/a/b")
+	j.SetCookies(from, nil, parseSetCookies(
+		"host=1", // host-only, default path /a
+		"domain=2; Domain=example.com; Path=/",
+		"deep=3; Path=/a/b/c",
+		"suffix=4; Domain=com",        // public suffix, rejected
+		"other=5; Domain=example.org", // domain mismatch, rejected
+	))
+	for _, cas := range []struct{ url, expected string }{
+		{"https://www.example.com/a/b/c/d", "deep=3; host=1; domain=2"},
+		{"https://www.example.com/a", "host=1; domain=2"},
+		{"https://www.example.com/ab", "domain=2"},
+		{"https://sub.example.com/a", "domain=2"},
+		{"https://example.com/", "domain=2"},
+		{"https://another.com/", ""},
+	} {
+		if got := cookieString(j.Cookies(mustParse(t, cas.url), nil, "GET")); got != cas.expected {
+			t.Errorf("%s: got %q, expected %q", cas.url, got, cas.expected)
+		}
+	}
+}
+
+func TestJarSecureAndPrefixes(t *testing.T) {
+	j, _ := New(nil)
+	j.SetCookies(mustParse(t, "http://example.com/"), nil, parseSetCookies(
+		"a=1; Secure", "__Secure-b=2", "c=3; SameSite=None",
+	))
+	if got := j.Cookies(mustParse(t, "https://example.com/"), nil, "GET"); len(got) != 0 {
+		t.Errorf("insecure origin set secure cookies: %v", got)
+	}
+
+	j.SetCookies(mustParse(t, "https://example.com/"), nil, parseSetCookies(
+		"a=1; Secure", "__Host-b=2; Secure; Path=/", "__Host-c=3; Secure; Domain=example.com",
+	))
+	if got := cookieString(j.Cookies(mustParse(t, "https://example.com/"), nil, "GET")); got != "a=1; __Host-b=2" {
+		t.Errorf("unexpected cookies %q", got)
+	}
+	if got := j.Cookies(mustParse(t, "http://example.com/"), nil, "GET"); len(got) != 0 {
+		t.Errorf("secure cookies sent to insecure origin: %v", got)
+	}
+	// insecure origins can't overlay secure cookies
+	j.SetCookies(mustParse(t, "http://example.com/"), nil, parseSetCookies("a=evil"))
+	if got := cookieString(j.Cookies(mustParse(t, "https://example.com/"), nil, "GET")); got != "a=1; __Host-b=2" {
+		t.Errorf("unexpected cookies %q", got)
+	}
+}
+
+func TestJarSameSite(t *testing.T) {
+	j, _ := New(nil)
+	u := mustParse(t, "https://www.example.com/")
+	j.SetCookies(u, nil, parseSetCookies("strict=1; SameSite=Strict", "lax=2; SameSite=Lax", "none=3; SameSite=None; Secure"))
+
+	for _, cas := range []struct {
+		site, method, expected string
+	}{
+		{"https://api.example.com/", "POST", "strict=1; lax=2; none=3"},
+		{"https://other.com/", "GET", "lax=2; none=3"},
+		{"https://other.com/", "POST", "none=3"},
+		{"http://www.example.com/", "GET", "lax=2; none=3"}, // schemeful same-site
+	} {
+		if got := cookieString(j.Cookies(u, mustParse(t, cas.site), cas.method)); got != cas.expected {
+			t.Errorf("%s %s: got %q, expected %q", cas.method, cas.site, got, cas.expected)
+		}
+	}
+}
+
+func TestJarExpiry(t *testing.T) {
+	j, _ := New(nil)
+	now := time.Now()
+	j.now = func() time.Time { return now }
+	u := mustParse(t, "https://example.com/")
+	j.SetCookies(u, nil, parseSetCookies("a=1; Max-Age=10", "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "c=3", "d=4; Max-Age=10"))
+	if got := cookieString(j.Cookies(u, nil, "GET")); got != "a=1; c=3; d=4" {
+		t.Errorf("unexpected cookies %q", got)
+	}
+	j.SetCookies(u, nil, parseSetCookies("d=4; Max-Age=0")) // deletes the cookie
+	now = now.Add(20 * time.Second)
+	if got := cookieString(j.Cookies(u, nil, "GET")); got != "c=3" {
+		t.Errorf("unexpected cookies %q", got)
+	}
+}
+
+func TestJarFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cookies.json")
+	j, err := New(&Options{Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := mustParse(t, "https://example.com/")
+	j.SetCookies(u, nil, parseSetCookies("persistent=1; Max-Age=3600", "session=2"))
+	if err := j.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = New(&Options{Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cookieString(j.Cookies(u, nil, "GET")); got != "persistent=1" {
+		t.Errorf("unexpected cookies after reload %q", got)
+	}
+}
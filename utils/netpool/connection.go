@@ -11,6 +11,11 @@ type state struct {
 	p        *Pool
 	IsClosed uint32
 	LastIdle time.Time
+	gen      uint32 // see [Pool.gen]
+
+	// below are only used by [MultiplexConn]s, guarded by p.muShared
+	sessions uint32 // number of sessions currently using the connection
+	draining bool   // removed from the pool, waiting for sessions to finish
 }
 
 func (c *state) Available() bool {
@@ -21,7 +26,10 @@ func (c *state) Release(close bool) (reused bool, err error) {
 	if !c.Available() {
 		return false, errors.New("called Release on closed connection")
 	}
-	if close {
+	if _, ok := c.conn.(MultiplexConn); ok {
+		return c.p.releaseShared(c, close)
+	}
+	if close || c.gen != atomic.LoadUint32(&c.p.gen) {
 		return false, c.Close()
 	}
 	c.LastIdle = time.Now()
@@ -35,8 +43,10 @@ func (c *state) Release(close bool) (reused bool, err error) {
 }
 
 func (c *state) Close() error {
+	if !atomic.CompareAndSwapUint32(&c.IsClosed, 0, 1) {
+		return nil
+	}
 	err := c.conn.Close()
-	atomic.StoreUint32(&c.IsClosed, 1)
 	if c.p.connTicket != nil {
 		<-c.p.connTicket
 	}
@@ -42,3 +42,12 @@ func (g *PoolGroup) Connect(ctx context.Context, key interface{}, dial func(ctx
 	g.Unlock()
 	return p.Connect(ctx, dial)
 }
+
+// Close closes the connections in all pools, see [Pool.Close]
+func (g *PoolGroup) Close() {
+	g.RLock()
+	defer g.RUnlock()
+	for _, p := range g.pools {
+		p.Close()
+	}
+}
@@ -2,6 +2,8 @@ package netpool
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,6 +13,16 @@ type Conn interface {
 	Close() error // called by netpool
 }
 
+// MultiplexConn is a [Conn] that could serve multiple sessions at the
+// same time, e.g. an http2 connection. Instead of being handed out to
+// a single caller, it stays shared in the pool until saturated.
+type MultiplexConn interface {
+	Conn
+	// Capacity reports the number of sessions the connection is able to serve
+	// concurrently. Zero means no new sessions are accepted, e.g. GOAWAY seen.
+	Capacity() uint32
+}
+
 type Session interface {
 	Release(close bool) (reused bool, err error)
 }
@@ -19,55 +31,258 @@ type Pool struct {
 	connTicket      chan interface{}
 	idleTicket      chan *state
 	maxIdleDuration time.Duration
+
+	muShared    sync.Mutex
+	shared      []*state      // multiplexed connections, may be handed out concurrently
+	freed       chan struct{} // closed and renewed whenever a shared connection gets spare capacity
+	multiplexed bool          // the pool has seen a connection serving multiple sessions
+	dialing     chan struct{} // closed when the pending dial finishes, only used if multiplexed
+
+	gen uint32 // increased on Close, connections from previous generations are closed on release
 }
 
 func NewPool(maxIdle, maxConn uint, maxIdleDuration time.Duration) (p *Pool) {
-	p = &Pool{idleTicket: make(chan *state, maxIdle), maxIdleDuration: maxIdleDuration}
+	p = &Pool{
+		idleTicket:      make(chan *state, maxIdle),
+		maxIdleDuration: maxIdleDuration,
+		freed:           make(chan struct{}),
+	}
 	if maxConn != 0 {
 		p.connTicket = make(chan interface{}, maxConn)
 	}
 	return
 }
 
-func (p *Pool) tryGetConn() (got *state, ok bool) { // true for got conn, false for need new connection
+// Close closes all idle connections, and connections in use are closed
+// after released. Multiplexed connections are closed immediately, which
+// is expected to be done gracefully by the [MultiplexConn] implementation.
+// The pool is still usable after closed.
+func (p *Pool) Close() {
+	atomic.AddUint32(&p.gen, 1)
+	p.muShared.Lock()
+	shared := p.shared
+	p.shared = nil
+	p.broadcastFreed()
+	p.muShared.Unlock()
+	for _, c := range shared {
+		c.Close()
+	}
+	for {
+		select {
+		case c := <-p.idleTicket:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (p *Pool) expired(c *state) bool {
+	return p.maxIdleDuration != 0 && time.Since(c.LastIdle) > p.maxIdleDuration
+}
+
+// broadcastFreed wakes up callers waiting for capacity, must hold muShared
+func (p *Pool) broadcastFreed() {
+	close(p.freed)
+	p.freed = make(chan struct{})
+}
+
+// removeShared must hold muShared
+func (p *Pool) removeShared(c *state) {
+	for i := range p.shared {
+		if p.shared[i] == c {
+			p.shared = append(p.shared[:i], p.shared[i+1:]...)
+			return
+		}
+	}
+}
+
+// tryGetShared picks a multiplexed connection with spare capacity, and retires
+// the ones that could no longer accept new sessions.
+func (p *Pool) tryGetShared() *state {
+	var retired []*state
+	defer func() {
+		for _, c := range retired {
+			c.Close()
+		}
+	}()
+	p.muShared.Lock()
+	defer p.muShared.Unlock()
+	for i := 0; i < len(p.shared); i++ {
+		c := p.shared[i]
+		capacity := c.conn.(MultiplexConn).Capacity()
+		if !c.Available() || capacity == 0 || (c.sessions == 0 && p.expired(c)) {
+			p.shared = append(p.shared[:i], p.shared[i+1:]...)
+			i--
+			if c.sessions == 0 {
+				retired = append(retired, c)
+			} else {
+				c.draining = true // closed after the last session is released
+			}
+			continue
+		}
+		if capacity > 1 {
+			p.multiplexed = true
+		}
+		if c.sessions < capacity {
+			c.sessions++
+			return c
+		}
+	}
+	return nil
+}
+
+func (p *Pool) addShared(c *state) {
+	p.muShared.Lock()
+	c.sessions = 1
+	p.shared = append(p.shared, c)
+	if c.conn.(MultiplexConn).Capacity() > 1 {
+		p.multiplexed = true
+	}
+	p.broadcastFreed()
+	p.muShared.Unlock()
+}
+
+func (p *Pool) releaseShared(c *state, close bool) (reused bool, err error) {
+	p.muShared.Lock()
+	c.sessions--
+	if c.sessions == 0 {
+		c.LastIdle = time.Now()
+		if p.maxIdleDuration != 0 {
+			time.AfterFunc(p.maxIdleDuration, func() { p.evictShared(c) })
+		}
+	}
+	drop := close || (c.sessions == 0 && (c.draining || c.conn.(MultiplexConn).Capacity() == 0))
+	if drop {
+		p.removeShared(c)
+	}
+	p.broadcastFreed()
+	p.muShared.Unlock()
+	if drop {
+		return false, c.Close()
+	}
+	return true, nil
+}
+
+// evictShared closes the multiplexed connection if it's been idle for too long
+func (p *Pool) evictShared(c *state) {
+	p.muShared.Lock()
+	evict := c.sessions == 0 && p.expired(c)
+	if evict {
+		p.removeShared(c)
+	}
+	p.muShared.Unlock()
+	if evict {
+		c.Close()
+	}
+}
+
+// reserveDial makes sure only one connection is being dialed at a time
+// once the pool knows that connections are multiplexed, since the new
+// connection could probably serve all the waiting callers.
+// returns a channel to wait on if another dial is pending.
+func (p *Pool) reserveDial() (wait <-chan struct{}, done func()) {
+	p.muShared.Lock()
+	defer p.muShared.Unlock()
+	if !p.multiplexed {
+		return nil, func() {}
+	}
+	if p.dialing != nil {
+		return p.dialing, nil
+	}
+	ch := make(chan struct{})
+	p.dialing = ch
+	return nil, func() {
+		p.muShared.Lock()
+		close(ch)
+		p.dialing = nil
+		p.muShared.Unlock()
+	}
+}
+
+// tryGetConn returns true for got conn, false for need new connection.
+// when a new connection is needed, dialDone must be called after dial.
+func (p *Pool) tryGetConn(ctx context.Context) (got *state, ok bool, dialDone func(), err error) {
 	for {
+		if c := p.tryGetShared(); c != nil {
+			return c, true, nil, nil
+		}
 		select {
 		case c := <-p.idleTicket:
-			if p.maxIdleDuration != 0 && time.Since(c.LastIdle) > p.maxIdleDuration {
+			if p.expired(c) {
 				c.Close()
 			} else if c.Available() {
-				return c, true
+				return c, true, nil, nil
 			}
 			continue
 		default:
 		}
-		if p.connTicket != nil {
+		wait, done := p.reserveDial()
+		if wait != nil {
 			select {
-			case c := <-p.idleTicket:
-				if p.maxIdleDuration != 0 && time.Since(c.LastIdle) > p.maxIdleDuration {
-					c.Close()
-				} else if c.Available() {
-					return c, true
-				}
+			case <-wait:
 				continue
-			case p.connTicket <- nil:
-				return nil, false
+			case <-ctx.Done():
+				return nil, false, nil, ctx.Err()
 			}
 		}
-		return
+		if p.connTicket == nil {
+			return nil, false, done, nil
+		}
+		p.muShared.Lock()
+		freed := p.freed
+		p.muShared.Unlock()
+		select {
+		case c := <-p.idleTicket:
+			done()
+			if p.expired(c) {
+				c.Close()
+			} else if c.Available() {
+				return c, true, nil, nil
+			}
+			continue
+		case p.connTicket <- nil:
+			return nil, false, done, nil
+		case <-freed:
+			done()
+			continue
+		case <-ctx.Done():
+			done()
+			return nil, false, nil, ctx.Err()
+		}
 	}
 }
 
 func (p *Pool) Connect(ctx context.Context, dial func(ctx context.Context) (Conn, error)) (Session, error) {
-	if got, ok := p.tryGetConn(); ok {
+	got, ok, dialDone, err := p.tryGetConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
 		return got.conn.Session(ctx, got)
 	}
-	c, err := dial(ctx)
+	c, err := p.dial(ctx, dial)
 	if err != nil {
+		dialDone()
 		return nil, err
 	}
-	if err := c.Setup(ctx); err != nil {
-		return nil, err
+	st := &state{conn: c, p: p, gen: atomic.LoadUint32(&p.gen)}
+	if _, ok := c.(MultiplexConn); ok {
+		p.addShared(st)
+	}
+	dialDone()
+	return c.Session(ctx, st)
+}
+
+func (p *Pool) dial(ctx context.Context, dial func(ctx context.Context) (Conn, error)) (Conn, error) {
+	c, err := dial(ctx)
+	if err == nil {
+		if err = c.Setup(ctx); err != nil {
+			c.Close()
+		}
+	}
+	if err != nil && p.connTicket != nil {
+		<-p.connTicket
 	}
-	return c.Session(ctx, &state{conn: c, p: p})
+	return c, err
 }
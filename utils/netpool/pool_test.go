@@ -0,0 +1,161 @@
+package netpool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testConn struct {
+	capacity uint32
+	closed   uint32
+}
+
+func (c *testConn) Setup(context.Context) error { return nil }
+func (c *testConn) Session(_ context.Context, s Session) (Session, error) {
+	return s, nil
+}
+func (c *testConn) Close() error {
+	atomic.StoreUint32(&c.closed, 1)
+	return nil
+}
+
+type testMuxConn struct{ testConn }
+
+func (c *testMuxConn) Capacity() uint32 { return atomic.LoadUint32(&c.capacity) }
+
+func TestPoolMultiplexed(t *testing.T) {
+	p := NewPool(10, 10, time.Minute)
+	var dialed []*testMuxConn
+	var mu sync.Mutex
+	dial := func(context.Context) (Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		c := &testMuxConn{testConn{capacity: 2}}
+		dialed = append(dialed, c)
+		return c, nil
+	}
+
+	var sessions []Session
+	for i := 0; i < 3; i++ {
+		s, err := p.Connect(context.Background(), dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, s)
+	}
+	if len(dialed) != 2 {
+		t.Fatalf("expected 2 connections for 3 sessions with capacity 2, got %d", len(dialed))
+	}
+
+	// GOAWAY on the first connection, new sessions should not use it
+	atomic.StoreUint32(&dialed[0].capacity, 0)
+	s, err := p.Connect(context.Background(), dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions = append(sessions, s)
+	if len(dialed) != 2 {
+		t.Fatalf("expected spare capacity on the second connection, dialed %d", len(dialed))
+	}
+	if atomic.LoadUint32(&dialed[0].closed) != 0 {
+		t.Fatal("draining connection closed with active sessions")
+	}
+	sessions[0].Release(false)
+	sessions[1].Release(false)
+	if atomic.LoadUint32(&dialed[0].closed) != 1 {
+		t.Fatal("draining connection not closed after sessions released")
+	}
+	for _, s := range sessions[2:] {
+		if reused, err := s.Release(false); !reused || err != nil {
+			t.Fatal("connection not reused", err)
+		}
+	}
+}
+
+func TestPoolMultiplexedConcurrentDial(t *testing.T) {
+	p := NewPool(10, 10, time.Minute)
+	var dialed int32
+	dial := func(context.Context) (Conn, error) {
+		atomic.AddInt32(&dialed, 1)
+		time.Sleep(10 * time.Millisecond)
+		return &testMuxConn{testConn{capacity: 100}}, nil
+	}
+	s, err := p.Connect(context.Background(), dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Release(true) // pool now knows connections are multiplexed
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Connect(context.Background(), dial); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&dialed); n != 2 {
+		t.Fatalf("expected concurrent sessions to share one new connection, dialed %d", n)
+	}
+}
+
+func TestPoolConnLimit(t *testing.T) {
+	p := NewPool(1, 1, time.Minute)
+	dial := func(context.Context) (Conn, error) { return &testConn{}, nil }
+	s, err := p.Connect(context.Background(), dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Connect(ctx, dial); err != context.DeadlineExceeded {
+		t.Fatal("expected waiting for connection ticket, got", err)
+	}
+	s.Release(false)
+	if _, err := p.Connect(context.Background(), dial); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolMultiplexedIdleEviction(t *testing.T) {
+	p := NewPool(10, 10, 20*time.Millisecond)
+	c := &testMuxConn{testConn{capacity: 10}}
+	s, err := p.Connect(context.Background(), func(context.Context) (Conn, error) { return c, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Release(false)
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadUint32(&c.closed) != 1 {
+		t.Fatal("idle multiplexed connection not evicted")
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	p := NewPool(10, 10, time.Minute)
+	var conns []*testConn
+	dial := func(context.Context) (Conn, error) {
+		c := &testConn{}
+		conns = append(conns, c)
+		return c, nil
+	}
+	idle, _ := p.Connect(context.Background(), dial)
+	inUse, _ := p.Connect(context.Background(), dial)
+	idle.Release(false)
+	p.Close()
+	if atomic.LoadUint32(&conns[0].closed) != 1 {
+		t.Fatal("idle connection not closed")
+	}
+	if atomic.LoadUint32(&conns[1].closed) != 0 {
+		t.Fatal("connection in use closed")
+	}
+	if reused, _ := inUse.Release(false); reused || atomic.LoadUint32(&conns[1].closed) != 1 {
+		t.Fatal("connection in use not closed after released")
+	}
+}